@@ -1,15 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/philhofer/rkive"
+	"io"
 )
 
 // This example demonstrates
-// how to use the Duplicator
-// interface to use the "Async" methods
-// defined by the client.
+// how to use typed buckets and
+// the "Async" methods defined by
+// the client.
 
 // "Person" will be the data-type used for this example
 type Person struct {
@@ -37,19 +39,20 @@ func (p *Person) Unmarshal(b []byte) error {
 	return json.Unmarshal(b, p)
 }
 
-// NewEmpty is the method required to satisfy the Duplicator
-// interface. It is almost always as simple as returning a reference
-// to a zero-initialized value of the same type as the method receiver.
-func (p *Person) NewEmpty() rkive.Object { return &Person{} }
-
 func main() {
 
 	// first, we need to set up a client. make sure
 	// you have the database running locally first.
 	riak, err := rkive.DialOne("localhost:8087", "demo-client")
+	if err != nil {
+		panic(err)
+	}
 
-	// we'll use the "people" bucket for Person structs
-	people := riak.Bucket("people")
+	// we'll use the "people" bucket for Person structs.
+	// a typed bucket knows the type of its values, so
+	// we never need to type-assert them, and Person
+	// doesn't need to implement rkive.Duplicator.
+	people := rkive.Typed[Person](riak.Bucket("people"))
 
 	// let's make some people
 	// and put them in the database
@@ -65,13 +68,13 @@ func main() {
 
 	// here we're putting "bob" in the "people"
 	// bucket under the key "bob"
-	err = people.New(bob, &bob.First)
+	err = people.Create(bob, &bob.First)
 	if err != nil {
 		panic(err)
 	}
 
 	// ... and we'll do the same with joe
-	err = people.New(joe, &joe.First)
+	err = people.Create(joe, &joe.First)
 	if err != nil {
 		panic(err)
 	}
@@ -79,22 +82,27 @@ func main() {
 	// Now this is where things get more interesting.
 	// We can retrieve both the "bob" and "joe" objects
 	// asynchronously:
-	results := people.MultiFetchAsync(&Person{}, 2, "Bob", "Joe")
+	// (at most two fetches are in flight at once)
+	results := people.MultiGet(context.Background(), &rkive.FetchOpts{Window: 2}, "Bob", "Joe")
 
 	// and now we can iterate through
 	// the results and print them out.
-	for res := range results {
+	for {
+		res, err := results.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			panic(err)
+		}
 		// results return a "Value" field
 		// and an "Error" field.
 		if res.Error != nil {
-			panic(err)
+			panic(res.Error)
 		}
 
-		// res.Value can always be type-asserted
-		// to the same type as the result returned
-		// from NewEmpty()
-		person := res.Value.(*Person)
-		fmt.Printf("%s %s\n", person.First, person.Last)
+		// res.Value is already a *Person
+		fmt.Printf("%s %s\n", res.Value.First, res.Value.Last)
 	}
 
 	// Here's another trick: let's make
@@ -117,24 +125,27 @@ func main() {
 
 	// now we can fetch all people
 	// with the last name "Johnson":
-	res, err := people.IndexLookup("lastname", "Johnson")
+	// Riak only returns keys for secondary
+	// index queries, but rkive fetches the
+	// values as the keys arrive, and returns
+	// them the same way as MultiGet:
+	stream, err := people.Query(context.Background(), &rkive.FetchOpts{Window: 2}, "lastname", "Johnson")
 	if err != nil {
 		panic(err)
 	}
 
-	// Riak only returns keys for secondary
-	// index queries. However, rkive gives you
-	// a quick way to fetch all of them that
-	// looks a lot like the one we used before:
-	stream := res.FetchAsync(&Person{}, 2)
-
-	// ... and we can use it the same way:
 	fmt.Print("\n")
 	fmt.Println("All the Johnsons: ")
 	fmt.Println("------------------")
-	for v := range stream {
-		person := v.Value.(*Person)
-		fmt.Printf("%s %s\n", person.First, person.Last)
+	for {
+		v, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s %s\n", v.Value.First, v.Value.Last)
 	}
 
 	// now let's do some cleanup.
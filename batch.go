@@ -0,0 +1,208 @@
+package rkive
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrSkipped is the error returned for the
+	// items of a fail-fast batch that were not
+	// attempted because an earlier item failed.
+	ErrSkipped = errors.New("skipped after an earlier failure")
+)
+
+// BatchOpts are the options for batch
+// operations (MultiNew, MultiStore, MultiPush
+// and MultiDelete). The zero value of BatchOpts,
+// and a nil *BatchOpts, perform one operation at a
+// time with no rate limit and no retries, returning
+// results in completion order.
+//
+// Retries are only safe for operations that can be
+// repeated: a write that fails with a connection error
+// may have been applied anyway, so a retried New can
+// create a duplicate object, and a retried Push can fail
+// with ErrModified or overwrite a concurrent write. For
+// that reason, Retries applies to MultiDelete, and only
+// applies to MultiNew, MultiStore and MultiPush if
+// RetryWrites is set.
+type BatchOpts struct {
+	Procs       int        // maximum number of concurrent operations
+	Rate        int        // maximum number of operations started per second (0 = unlimited)
+	Retries     int        // number of retries after connection errors
+	RetryWrites bool       // also retry MultiNew, MultiStore and MultiPush
+	FailFast    bool       // skip the remaining items after the first failure
+	Ordered     bool       // return results in the same order as the input
+	Write       *WriteOpts // options for writes
+	Delete      *DelOpts   // options for deletes
+}
+
+// BatchResult is the result of one item
+// in a batch operation.
+type BatchResult struct {
+	Index    int    // position of the item in the input
+	Key      string // key of the item
+	Value    Object // the object (nil for MultiDelete)
+	Error    error  // error, if any
+	Attempts int    // number of attempts made
+}
+
+// MultiNew calls New on every object. The key of each object
+// is used if it has one; otherwise Riak assigns a key. (See: New)
+// The returned channel yields one result for every object,
+// and is closed once every result has been sent.
+func (b *Bucket) MultiNew(opts *BatchOpts, objs ...Object) <-chan *BatchResult {
+	return b.batch(len(objs), opts, false, fillObjects(objs), func(r *BatchResult, o *BatchOpts) error {
+		ob := r.Value
+		var key *string
+		if k := ob.Info().Key(); k != "" {
+			key = &k
+		}
+		err := b.c.New(ob, b.nm, key, o.Write)
+		r.Key = ob.Info().Key()
+		return err
+	})
+}
+
+// MultiStore calls Store on every object. (See: MultiNew)
+func (b *Bucket) MultiStore(opts *BatchOpts, objs ...Object) <-chan *BatchResult {
+	return b.batch(len(objs), opts, false, fillObjects(objs), func(r *BatchResult, o *BatchOpts) error {
+		return b.c.Store(r.Value, o.Write)
+	})
+}
+
+// MultiPush calls Push on every object. (See: MultiNew)
+func (b *Bucket) MultiPush(opts *BatchOpts, objs ...Object) <-chan *BatchResult {
+	return b.batch(len(objs), opts, false, fillObjects(objs), func(r *BatchResult, o *BatchOpts) error {
+		return b.c.Push(r.Value, o.Write)
+	})
+}
+
+// MultiDelete calls DeleteKey on every key. (See: MultiNew)
+func (b *Bucket) MultiDelete(opts *BatchOpts, keys ...string) <-chan *BatchResult {
+	return b.batch(len(keys), opts, true, func(r *BatchResult) { r.Key = keys[r.Index] }, func(r *BatchResult, o *BatchOpts) error {
+		return b.c.DeleteKey(b.nm, r.Key, o.Delete)
+	})
+}
+
+// fillObjects fills in the key and
+// value of results from 'objs'
+func fillObjects(objs []Object) func(r *BatchResult) {
+	return func(r *BatchResult) {
+		ob := objs[r.Index]
+		r.Value, r.Key = ob, ob.Info().Key()
+	}
+}
+
+// errors worth retrying are the ones
+// caused by the connection, not the request
+func retryable(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return true
+	}
+	return errors.Is(err, ErrUnavail) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// batch runs 'op' on 'n' items; 'idempotent'
+// ops are retried without RetryWrites. 'fill'
+// fills in each result from the input, so that
+// skipped items can be identified.
+func (b *Bucket) batch(n int, opts *BatchOpts, idempotent bool, fill func(r *BatchResult), op func(r *BatchResult, o *BatchOpts) error) <-chan *BatchResult {
+	var o BatchOpts
+	if opts != nil {
+		o = *opts
+	}
+	if !idempotent && !o.RetryWrites {
+		o.Retries = 0
+	}
+	if o.Procs <= 0 {
+		o.Procs = 1
+	}
+	if o.Procs > n {
+		o.Procs = n
+	}
+
+	// rate limiter; ticks are dropped
+	// while every worker is busy
+	var tick *time.Ticker
+	var limit <-chan time.Time
+	if o.Rate > 0 && time.Second/time.Duration(o.Rate) > 0 {
+		tick = time.NewTicker(time.Second / time.Duration(o.Rate))
+		limit = tick.C
+	}
+
+	idx := make(chan int, n)
+	for i := 0; i < n; i++ {
+		idx <- i
+	}
+	close(idx)
+
+	done := make(chan *BatchResult, n)
+	out := done
+	if o.Ordered {
+		out = make(chan *BatchResult, n)
+	}
+
+	var failed int32
+	wg := new(sync.WaitGroup)
+	wg.Add(o.Procs)
+	for j := 0; j < o.Procs; j++ {
+		go func() {
+			for i := range idx {
+				r := &BatchResult{Index: i}
+				fill(r)
+				if atomic.LoadInt32(&failed) == 1 {
+					r.Error = ErrSkipped
+					done <- r
+					continue
+				}
+				for {
+					if limit != nil {
+						<-limit
+					}
+					r.Attempts++
+					r.Error = op(r, &o)
+					if r.Error == nil || r.Attempts > o.Retries || !retryable(r.Error) {
+						break
+					}
+				}
+				if r.Error != nil && o.FailFast {
+					atomic.StoreInt32(&failed, 1)
+				}
+				done <- r
+			}
+			wg.Done()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		if tick != nil {
+			tick.Stop()
+		}
+		close(done)
+	}()
+
+	// re-sequence results
+	if o.Ordered {
+		go func() {
+			next := 0
+			held := make(map[int]*BatchResult)
+			for r := range done {
+				held[r.Index] = r
+				for r, ok := held[next]; ok; r, ok = held[next] {
+					delete(held, next)
+					out <- r
+					next++
+				}
+			}
+			close(out)
+		}()
+	}
+	return out
+}
@@ -0,0 +1,139 @@
+package rkive
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// keys are the item's index
+func fillIndex(r *BatchResult) { r.Key = strconv.Itoa(r.Index) }
+
+func TestBatchOrdered(t *testing.T) {
+	b := &Bucket{}
+	opts := &BatchOpts{Procs: 4, Ordered: true}
+	res := b.batch(20, opts, true, fillIndex, func(r *BatchResult, o *BatchOpts) error {
+		// finish out of order
+		time.Sleep(time.Duration(20-r.Index) * time.Millisecond)
+		return nil
+	})
+	next := 0
+	for r := range res {
+		if r.Index != next {
+			t.Fatalf("expected result %d; got %d", next, r.Index)
+		}
+		if r.Error != nil || r.Attempts != 1 {
+			t.Errorf("bad result: %+v", r)
+		}
+		next++
+	}
+	if next != 20 {
+		t.Errorf("got %d results; expected 20", next)
+	}
+}
+
+func TestBatchFailFast(t *testing.T) {
+	b := &Bucket{}
+	bad := errors.New("bad")
+	opts := &BatchOpts{FailFast: true, Retries: 2}
+	objs := make([]Object, 5)
+	for i := range objs {
+		objs[i] = &Blob{}
+		objs[i].Info().key = []byte(strconv.Itoa(i))
+	}
+	res := b.batch(5, opts, true, fillObjects(objs), func(r *BatchResult, o *BatchOpts) error {
+		switch r.Index {
+		case 1:
+			return io.EOF
+		case 2:
+			return bad
+		}
+		return nil
+	})
+	var out []*BatchResult
+	for r := range res {
+		out = append(out, r)
+	}
+	if len(out) != 5 {
+		t.Fatalf("got %d results; expected 5", len(out))
+	}
+	// one worker, so completion order is input order
+	if out[0].Error != nil {
+		t.Errorf("item 0: %v", out[0].Error)
+	}
+	if out[1].Error != io.EOF || out[1].Attempts != 3 {
+		t.Errorf("item 1 should be retried twice: %+v", out[1])
+	}
+	if out[2].Error != ErrSkipped || out[2].Attempts != 0 {
+		t.Errorf("item 2 should be skipped: %+v", out[2])
+	}
+	for _, r := range out[3:] {
+		if r.Error != ErrSkipped {
+			t.Errorf("item %d should be skipped: %+v", r.Index, r)
+		}
+	}
+	// skipped items are still identified
+	for _, r := range out {
+		if r.Key != strconv.Itoa(r.Index) || r.Value != objs[r.Index] {
+			t.Errorf("item %d has key %q and value %v", r.Index, r.Key, r.Value)
+		}
+	}
+
+	// best-effort attempts everything
+	opts.FailFast = false
+	n := 0
+	for r := range b.batch(5, opts, true, fillIndex, func(r *BatchResult, o *BatchOpts) error {
+		if r.Index == 2 {
+			return bad
+		}
+		return nil
+	}) {
+		if r.Error == ErrSkipped {
+			t.Errorf("item %d was skipped", r.Index)
+		}
+		if r.Error == bad && r.Attempts != 1 {
+			t.Errorf("non-network errors shouldn't be retried: %+v", r)
+		}
+		n++
+	}
+	if n != 5 {
+		t.Errorf("got %d results; expected 5", n)
+	}
+}
+
+func TestBatchRate(t *testing.T) {
+	b := &Bucket{}
+	start := time.Now()
+	n := 0
+	for range b.batch(5, &BatchOpts{Procs: 5, Rate: 100}, true, fillIndex, func(r *BatchResult, o *BatchOpts) error { return nil }) {
+		n++
+	}
+	if n != 5 {
+		t.Errorf("got %d results; expected 5", n)
+	}
+	if time.Since(start) < 40*time.Millisecond {
+		t.Errorf("5 operations at 100/s took %s", time.Since(start))
+	}
+	for range b.batch(0, nil, true, fillIndex, nil) {
+		t.Error("empty batch returned a result")
+	}
+}
+
+func TestBatchRetryWrites(t *testing.T) {
+	b := &Bucket{}
+	eof := func(r *BatchResult, o *BatchOpts) error { return io.EOF }
+	opts := &BatchOpts{Retries: 2}
+	for r := range b.batch(1, opts, false, fillIndex, eof) {
+		if r.Attempts != 1 {
+			t.Errorf("writes shouldn't be retried by default: %+v", r)
+		}
+	}
+	opts.RetryWrites = true
+	for r := range b.batch(1, opts, false, fillIndex, eof) {
+		if r.Attempts != 3 {
+			t.Errorf("writes should be retried with RetryWrites: %+v", r)
+		}
+	}
+}
@@ -30,6 +30,9 @@ func (b *Bucket) Store(o Object) error { return b.c.Store(o, nil) }
 // Update updates an object in a bucket
 func (b *Bucket) Update(o Object) (bool, error) { return b.c.Update(o, nil) }
 
+// DeleteKey deletes the object at the specified key
+func (b *Bucket) DeleteKey(key string) error { return b.c.DeleteKey(b.nm, key, nil) }
+
 // Overwrite performs an overwrite on the specified key
 func (b *Bucket) Overwrite(o Object, key string) error { return b.c.Overwrite(o, b.nm, key, nil) }
 
@@ -119,6 +122,7 @@ func (b *Bucket) Reset() error {
 // may return in any order. Every result on the channel will
 // have its "Value" field type-assertable to the underlying type of 'o'.
 // 'procs' goroutines will be used for fetching.
+// (See MultiFetchContext for a cancellable alternative.)
 func (b *Bucket) MultiFetchAsync(o Duplicator, procs int, keys ...string) <-chan *AsyncFetch {
 	if procs <= 0 {
 		procs = 1
@@ -133,7 +137,7 @@ func (b *Bucket) MultiFetchAsync(o Duplicator, procs int, keys ...string) <-chan
 			for key := range kc {
 				v := o.NewEmpty()
 				err := b.Fetch(v, key)
-				out <- &AsyncFetch{v, err}
+				out <- &AsyncFetch{Key: key, Value: v, Error: err}
 			}
 			wg.Done()
 		}()
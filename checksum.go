@@ -0,0 +1,101 @@
+package rkive
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/philhofer/rkive/rpbc"
+	"hash/crc32"
+	"sync/atomic"
+)
+
+const (
+	// MetaChecksum is the usermeta key under which
+	// the checksum of an object's value is stored.
+	// The checksum is the hex-encoded CRC-32C of the
+	// value as it is stored, after compression and
+	// encryption.
+	MetaChecksum = "rkive-crc32c"
+)
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	// number of checksum mismatches
+	nmismatch uint64
+)
+
+// ErrChecksumMismatch is the type of error returned
+// when a value read from the database does not match
+// the checksum stored with it.
+type ErrChecksumMismatch struct {
+	Bucket   string
+	Key      string
+	Expected string
+	Actual   string
+}
+
+func (e *ErrChecksumMismatch) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s/%s: expected %s; got %s", e.Bucket, e.Key, e.Expected, e.Actual)
+}
+
+// ChecksumMismatches returns the number of checksum
+// mismatches encountered since the program started.
+func ChecksumMismatches() uint64 { return atomic.LoadUint64(&nmismatch) }
+
+// SetChecksums enables or disables checksums for
+// every bucket. When checksums are enabled, the checksum
+// of every value written is stored in its usermeta.
+// Checksums are verified on every read whenever they
+// are present, regardless of this setting.
+func (c *Client) SetChecksums(on bool) {
+	c.setConf(nil, func(bc *bucketConf) { bc.sums = tristate(on) })
+}
+
+// SetChecksums enables or disables checksums for
+// the bucket, which overrides the client setting.
+func (b *Bucket) SetChecksums(on bool) {
+	b.c.setConf(&b.nm, func(bc *bucketConf) { bc.sums = tristate(on) })
+}
+
+func tristate(on bool) int8 {
+	if on {
+		return 1
+	}
+	return -1
+}
+
+func checksum(v []byte) []byte {
+	var sum [4]byte
+	crc := crc32.Checksum(v, crcTable)
+	sum[0], sum[1], sum[2], sum[3] = byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc)
+	out := make([]byte, 8)
+	hex.Encode(out, sum[:])
+	return out
+}
+
+// append the checksum of 'v' to 'meta'
+func addChecksum(v []byte, meta []*rpbc.RpbPair) []*rpbc.RpbPair {
+	return append(meta, &rpbc.RpbPair{
+		Key:   []byte(MetaChecksum),
+		Value: checksum(v),
+	})
+}
+
+// verify 'v' against the checksum in 'meta', if present
+func verify(o Object, v []byte, meta []*rpbc.RpbPair) error {
+	want := get(&meta, ustr(MetaChecksum))
+	if want == nil {
+		return nil
+	}
+	got := checksum(v)
+	if string(got) == string(want) {
+		return nil
+	}
+	atomic.AddUint64(&nmismatch, 1)
+	return &ErrChecksumMismatch{
+		Bucket:   o.Info().Bucket(),
+		Key:      o.Info().Key(),
+		Expected: string(want),
+		Actual:   string(got),
+	}
+}
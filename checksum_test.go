@@ -0,0 +1,77 @@
+package rkive
+
+import (
+	"github.com/philhofer/rkive/rpbc"
+	"testing"
+)
+
+func TestChecksums(t *testing.T) {
+	cf := &bucketConf{sums: 1}
+	cf.codec, _ = compression("gzip")
+	cf.cmin = 1
+
+	ob := &Blob{Content: []byte("an important value")}
+	ctnt := &rpbc.RpbContent{}
+	err := writeContent(ob, ctnt, nil, nil, cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if get(&ctnt.Usermeta, ustr(MetaChecksum)) == nil {
+		t.Fatal("no checksum was written")
+	}
+
+	out := &Blob{}
+	err = readContent(out, ctnt, &bucketConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// corrupt the stored value
+	ob.Content = ob.Content[:5]
+	bad := &rpbc.RpbContent{}
+	err = writeContent(ob, bad, nil, nil, &bucketConf{sums: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if get(&bad.Usermeta, ustr(MetaChecksum)) != nil {
+		t.Error("checksum shouldn't be written when disabled")
+	}
+	bad.Usermeta = []*rpbc.RpbPair{{Key: []byte(MetaChecksum), Value: get(&ctnt.Usermeta, ustr(MetaChecksum))}}
+
+	n := ChecksumMismatches()
+	out.Info().bucket, out.Info().key = []byte("b"), []byte("k")
+	err = readContent(out, bad, &bucketConf{})
+	cm, ok := err.(*ErrChecksumMismatch)
+	if !ok {
+		t.Fatalf("expected *ErrChecksumMismatch; got %v", err)
+	}
+	if cm.Bucket != "b" || cm.Key != "k" || cm.Expected == cm.Actual {
+		t.Errorf("bad error: %+v", cm)
+	}
+	if ChecksumMismatches() != n+1 {
+		t.Errorf("mismatch count: %d", ChecksumMismatches())
+	}
+}
+
+func TestChecksumEncrypted(t *testing.T) {
+	keys := &StaticKeys{Current: "k", Keys: map[string][]byte{"k": make([]byte, 32)}}
+	cf := &bucketConf{sums: 1, keys: keys}
+	plain := []byte("1234")
+	ctnt := &rpbc.RpbContent{}
+	err := writeContent(&Blob{Content: plain}, ctnt, []byte("b"), []byte("k"), cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := string(get(&ctnt.Usermeta, ustr(MetaChecksum)))
+	if sum == string(checksum(plain)) || sum != string(checksum(ctnt.Value)) {
+		t.Errorf("checksum %s should cover the ciphertext", sum)
+	}
+
+	// corruption is caught before decryption
+	ctnt.Value[0] ^= 0xff
+	out := &Blob{}
+	out.Info().bucket, out.Info().key = []byte("b"), []byte("k")
+	if _, ok := readContent(out, ctnt, cf).(*ErrChecksumMismatch); !ok {
+		t.Error("expected *ErrChecksumMismatch")
+	}
+}
@@ -61,6 +61,8 @@ func (r RiakError) Error() string {
 // ErrMultipleResponses is the type
 // of error returned when multiple
 // siblings are retrieved for an object.
+// Use FetchSiblings to inspect and resolve
+// the siblings.
 type ErrMultipleResponses struct {
 	Bucket      string
 	Key         string
@@ -98,9 +100,10 @@ func (r *Blob) Marshal() ([]byte, error) { return r.Content, nil }
 
 // conn is a connection
 type conn struct {
-	*net.TCPConn         // underlying connection
-	parent       *Client // parent Client
-	isClosed     bool    // has Close() been called?
+	*net.TCPConn               // underlying connection
+	parent       *Client       // parent Client
+	isClosed     bool          // has Close() been called?
+	rto          time.Duration // read timeout override (0 = readTimeout)
 }
 
 // write wraps the TCP write
@@ -111,7 +114,11 @@ func (c *conn) Write(b []byte) (int, error) {
 
 // read wraps the TCP read
 func (c *conn) Read(b []byte) (int, error) {
-	c.SetReadDeadline(time.Now().Add(readTimeout * time.Millisecond))
+	d := readTimeout * time.Millisecond
+	if c.rto > 0 {
+		d = c.rto
+	}
+	c.SetReadDeadline(time.Now().Add(d))
 	return c.TCPConn.Read(b)
 }
 
@@ -124,7 +131,7 @@ func (c *conn) Close() {
 	}
 	c.isClosed = true
 	logger.Printf("closing TCP connection to %s", c.RemoteAddr().String())
-	c.Close()
+	c.TCPConn.Close()
 	c.parent.dec()
 }
 
@@ -367,7 +374,7 @@ func (c *Client) req(msg protom, code byte, res unmarshaler) (byte, error) {
 	buf.Body = resbts // save the returned slice
 	if err != nil {
 		putBuf(buf)
-		return 0, fmt.Errorf("rkive: doBuf err: %s", err)
+		return 0, fmt.Errorf("rkive: doBuf err: %w", err)
 	}
 	if rescode == 0 {
 		riakerr := new(rpbc.RpbErrorResp)
@@ -464,8 +471,41 @@ func (s *streamRes) unmarshal(res protoStream) (bool, byte, error) {
 // return the connection to the client
 func (s *streamRes) close() { s.c.done(s.node) }
 
+// abort drops the connection entirely. it
+// must be used instead of close() when the
+// stream is abandoned before it is done, since
+// the connection will still have frames in-flight.
+func (s *streamRes) abort() {
+	s.node.Close()
+	atomic.AddInt32(&s.c.inuse, -1)
+}
+
 func (c *Client) streamReq(req protom, code byte) (*streamRes, error) {
+	return c.streamReqTimeout(req, code, 0)
+}
+
+// riak's timeout for streaming
+// operations that are sent without one
+const dfltStream = 60 * time.Second
+
+// streamTimeout is the read timeout for a stream
+// with the server-side timeout 'd' (0 = riak's
+// default). Responses can be as far apart as
+// the operation takes, and the read timeout has
+// to outlast riak's, so that riak's timeout error
+// arrives instead of an i/o timeout.
+func streamTimeout(d time.Duration) time.Duration {
+	if d <= 0 {
+		d = dfltStream
+	}
+	return d + readTimeout*time.Millisecond
+}
 
+// streamReqTimeout is like streamReq, but the
+// connection waits up to 'rto' for each read
+// (0 = readTimeout), for requests whose frames
+// can be far apart
+func (c *Client) streamReqTimeout(req protom, code byte, rto time.Duration) (*streamRes, error) {
 	buf := getBuf()
 	err := buf.Set(req)
 	if err != nil {
@@ -484,6 +524,7 @@ func (c *Client) streamReq(req protom, code byte) (*streamRes, error) {
 		c.err(node)
 		return nil, err
 	}
+	node.rto = rto
 	return &streamRes{c: c, node: node}, nil
 }
 
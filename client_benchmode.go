@@ -31,6 +31,8 @@ type Client struct {
 	id    []byte         // client ID
 	pool  sync.Pool      // connection pool
 	addrs []*net.TCPAddr // node addrs
+	list  int32          // 1 = list operations allowed
+	cfg   clientConf     // client and bucket configuration
 }
 
 func (c *Client) doBuf(code byte, msg []byte) ([]byte, byte, error) {
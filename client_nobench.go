@@ -19,6 +19,8 @@ type Client struct {
 	id    []byte         // client ID for writeClientID
 	pool  sync.Pool      // connection pool
 	addrs []*net.TCPAddr // addresses to dial
+	list  int32          // 1 = list operations allowed
+	cfg   clientConf     // client and bucket configuration
 }
 
 func (c *Client) doBuf(code byte, msg []byte) ([]byte, byte, error) {
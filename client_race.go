@@ -6,6 +6,7 @@ import "sync/atomic"
 
 // finish node (success)
 func (c *Client) done(n *conn) {
+	n.rto = 0
 	if c.closed() {
 		n.Close()
 	} else {
@@ -16,6 +17,7 @@ func (c *Client) done(n *conn) {
 
 // finish node (err)
 func (c *Client) err(n *conn) {
+	n.rto = 0
 	if c.closed() {
 		n.Close()
 	} else {
@@ -0,0 +1,206 @@
+package rkive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io/ioutil"
+	"sync"
+)
+
+const (
+	// DefaultCompressThreshold is the minimum size
+	// (in bytes) of a value that will be compressed.
+	DefaultCompressThreshold = 512
+
+	// MetaCodec is the usermeta key under which
+	// the encoding of an encrypted value is stored.
+	// (Encrypted values are stored without a
+	// content-encoding, since the stored bytes
+	// are ciphertext.)
+	MetaCodec = "rkive-codec"
+)
+
+var (
+	// ErrUnknownCodec is returned when a compression
+	// codec is requested that has not been registered.
+	ErrUnknownCodec = errors.New("unknown codec")
+
+	codecLock sync.RWMutex
+	codecs    = map[string]Codec{}
+)
+
+func init() {
+	RegisterCodec(gzipCodec{})
+	RegisterCodec(deflateCodec{})
+}
+
+// A Codec compresses and decompresses
+// object values. Codecs are identified by
+// name (e.g. "gzip"), which is stored as the
+// content-encoding of the compressed value, or
+// under MetaCodec if the value is encrypted.
+type Codec interface {
+	// Encoding is the name
+	// that identifies the codec.
+	Encoding() string
+
+	// Compress compresses a value.
+	Compress([]byte) ([]byte, error)
+
+	// Decompress decompresses a value.
+	Decompress([]byte) ([]byte, error)
+}
+
+// RegisterCodec makes a codec available for
+// compression (see SetCompression) and for transparent
+// decompression. Values stored with a content-encoding
+// that names a registered codec (including values written
+// by other clients) are always decompressed on read, regardless
+// of the bucket's compression settings, and are compressed again
+// on write as long as Info.ContentEncoding still names the codec.
+// Values with any other content-encoding are stored and returned
+// as-is. "gzip" and "deflate" are registered by default.
+func RegisterCodec(c Codec) {
+	codecLock.Lock()
+	codecs[c.Encoding()] = c
+	codecLock.Unlock()
+}
+
+// getCodec returns the codec registered
+// for an encoding, or nil
+func getCodec(enc []byte) Codec {
+	if len(enc) == 0 {
+		return nil
+	}
+	codecLock.RLock()
+	c := codecs[string(enc)]
+	codecLock.RUnlock()
+	return c
+}
+
+// SetCompression sets the default compression codec
+// for every bucket. Values at least 'threshold' bytes
+// long will be compressed with the codec registered
+// under 'encoding' on write. (A threshold <= 0 uses
+// DefaultCompressThreshold.) An empty 'encoding'
+// disables compression.
+func (c *Client) SetCompression(encoding string, threshold int) error {
+	cd, err := compression(encoding)
+	if err != nil {
+		return err
+	}
+	c.setConf(nil, func(bc *bucketConf) { bc.codec, bc.cmin, bc.comp = cd, threshold, tristate(cd != nil) })
+	return nil
+}
+
+// SetCompression sets the compression codec for
+// the bucket, which overrides the client's codec.
+// An empty 'encoding' disables compression for the
+// bucket even if the client compresses by default.
+// (See: Client.SetCompression)
+func (b *Bucket) SetCompression(encoding string, threshold int) error {
+	cd, err := compression(encoding)
+	if err != nil {
+		return err
+	}
+	b.c.setConf(&b.nm, func(bc *bucketConf) { bc.codec, bc.cmin, bc.comp = cd, threshold, tristate(cd != nil) })
+	return nil
+}
+
+func compression(encoding string) (Codec, error) {
+	if encoding == "" {
+		return nil, nil
+	}
+	cd := getCodec([]byte(encoding))
+	if cd == nil {
+		return nil, ErrUnknownCodec
+	}
+	return cd, nil
+}
+
+// compress 'v' with the codec named by the
+// explicit encoding 'enc' or the bucket settings;
+// returns the value and its encoding
+func compress(v []byte, enc []byte, cf *bucketConf) ([]byte, []byte, error) {
+	cd := getCodec(enc)
+	if cd == nil {
+		if len(enc) > 0 || cf.codec == nil {
+			return v, enc, nil
+		}
+		min := cf.cmin
+		if min <= 0 {
+			min = DefaultCompressThreshold
+		}
+		if len(v) < min {
+			return v, enc, nil
+		}
+		cd = cf.codec
+	}
+	out, err := cd.Compress(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, ustr(cd.Encoding()), nil
+}
+
+// decompress 'v' if 'enc' names a registered codec
+func decompress(v []byte, enc []byte) ([]byte, error) {
+	cd := getCodec(enc)
+	if cd == nil {
+		return v, nil
+	}
+	return cd.Decompress(v)
+}
+
+type gzipCodec struct{}
+
+func (g gzipCodec) Encoding() string { return "gzip" }
+
+func (g gzipCodec) Compress(v []byte) ([]byte, error) {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	_, err := w.Write(v)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	return b.Bytes(), err
+}
+
+func (g gzipCodec) Decompress(v []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(v))
+	if err != nil {
+		return nil, err
+	}
+	out, err := ioutil.ReadAll(r)
+	r.Close()
+	return out, err
+}
+
+// "deflate" is zlib-wrapped, per RFC 2616
+type deflateCodec struct{}
+
+func (d deflateCodec) Encoding() string { return "deflate" }
+
+func (d deflateCodec) Compress(v []byte) ([]byte, error) {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	_, err := w.Write(v)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	return b.Bytes(), err
+}
+
+func (d deflateCodec) Decompress(v []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(v))
+	if err != nil {
+		return nil, err
+	}
+	out, err := ioutil.ReadAll(r)
+	r.Close()
+	return out, err
+}
@@ -0,0 +1,134 @@
+package rkive
+
+import (
+	"bytes"
+	"github.com/philhofer/rkive/rpbc"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	cf := &bucketConf{}
+	cd, err := compression("gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf.codec, cf.cmin = cd, 64
+
+	if _, err = compression("snappy"); err != ErrUnknownCodec {
+		t.Errorf("expected ErrUnknownCodec; got %v", err)
+	}
+
+	big := &Blob{Content: bytes.Repeat([]byte("compress me! "), 100)}
+	ctnt := &rpbc.RpbContent{}
+	err = writeContent(big, ctnt, nil, nil, cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ctnt.ContentEncoding) != "gzip" {
+		t.Fatalf("ContentEncoding %q", ctnt.ContentEncoding)
+	}
+	if len(ctnt.Value) >= len(big.Content) {
+		t.Errorf("value was not compressed: %d bytes", len(ctnt.Value))
+	}
+
+	// values are decompressed regardless of
+	// the bucket settings
+	out := &Blob{}
+	err = readContent(out, ctnt, &bucketConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Content, big.Content) {
+		t.Error("value didn't round-trip")
+	}
+	if out.Info().ContentEncoding() != "gzip" {
+		t.Errorf("ContentEncoding: %q", out.Info().ContentEncoding())
+	}
+
+	// a value written back is compressed again,
+	// even if the bucket doesn't compress
+	ctnt = &rpbc.RpbContent{}
+	err = writeContent(out, ctnt, nil, nil, &bucketConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ctnt.ContentEncoding) != "gzip" || len(ctnt.Value) >= len(big.Content) {
+		t.Errorf("value wasn't compressed again; ContentEncoding %q", ctnt.ContentEncoding)
+	}
+
+	// below the threshold
+	small := &Blob{Content: []byte("tiny")}
+	ctnt = &rpbc.RpbContent{}
+	err = writeContent(small, ctnt, nil, nil, cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctnt.ContentEncoding != nil || !bytes.Equal(ctnt.Value, small.Content) {
+		t.Errorf("small value shouldn't be compressed")
+	}
+
+	// values stored by other clients
+	// are decompressed too
+	z, _ := deflateCodec{}.Compress(big.Content)
+	ctnt = &rpbc.RpbContent{Value: z, ContentEncoding: []byte("deflate")}
+	out = &Blob{}
+	err = readContent(out, ctnt, &bucketConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Content, big.Content) {
+		t.Error("deflated value wasn't decompressed")
+	}
+
+	// values with an unregistered encoding
+	// are stored and returned as-is
+	pre := &Blob{Content: []byte("already encoded")}
+	pre.Info().SetContentEncoding("snappy")
+	ctnt = &rpbc.RpbContent{}
+	err = writeContent(pre, ctnt, nil, nil, cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ctnt.ContentEncoding) != "snappy" || !bytes.Equal(ctnt.Value, pre.Content) {
+		t.Fatalf("ContentEncoding %q; value %q", ctnt.ContentEncoding, ctnt.Value)
+	}
+	out = &Blob{}
+	err = readContent(out, ctnt, &bucketConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Info().ContentEncoding() != "snappy" || !bytes.Equal(out.Content, pre.Content) {
+		t.Errorf("ContentEncoding %q; value %q", out.Info().ContentEncoding(), out.Content)
+	}
+}
+
+func TestBucketCompressionOff(t *testing.T) {
+	c := &Client{}
+	if err := c.SetCompression("gzip", 1); err != nil {
+		t.Fatal(err)
+	}
+	b := c.Bucket("plain")
+	if err := b.SetCompression("", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	cf := c.conf([]byte("plain"))
+	if cf.codec != nil {
+		t.Fatalf("bucket codec should be off; got %q", cf.codec.Encoding())
+	}
+	big := &Blob{Content: bytes.Repeat([]byte("compress me! "), 100)}
+	ctnt := &rpbc.RpbContent{}
+	err := writeContent(big, ctnt, nil, nil, &cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctnt.ContentEncoding != nil || !bytes.Equal(ctnt.Value, big.Content) {
+		t.Errorf("value shouldn't be compressed; ContentEncoding %q", ctnt.ContentEncoding)
+	}
+
+	// other buckets still use the client codec
+	cf = c.conf([]byte("other"))
+	if cf.codec == nil || cf.codec.Encoding() != "gzip" {
+		t.Error("other buckets should use the client codec")
+	}
+}
@@ -0,0 +1,91 @@
+package rkive
+
+import (
+	"sync"
+)
+
+// bucketConf holds the per-bucket
+// configuration of a client. Zero
+// values mean "use the client default."
+type bucketConf struct {
+	resolve Resolver    // sibling resolver
+	rslv    int8        // resolver (1 = set; -1 = cleared)
+	meta    MetaPolicy  // metadata merge policy
+	codec   Codec       // compression codec
+	cmin    int         // compression threshold
+	comp    int8        // compression (1 = codec; -1 = off)
+	keys    KeyProvider // encryption keys
+	crypt   int8        // encryption (1 = keys; -1 = off)
+	sums    int8        // checksums (1 = on; -1 = off)
+	schema  *schema     // upgrade registry
+	schm    int8        // upgrades (1 = schema; -1 = none)
+}
+
+// merge fills unset fields in 'b' from 'd'
+func (b *bucketConf) merge(d *bucketConf) {
+	if b.rslv == 0 {
+		b.resolve, b.rslv = d.resolve, d.rslv
+	}
+	if b.meta == 0 {
+		b.meta = d.meta
+	}
+	if b.comp == 0 {
+		b.codec, b.cmin, b.comp = d.codec, d.cmin, d.comp
+	}
+	if b.crypt == 0 {
+		b.keys, b.crypt = d.keys, d.crypt
+	}
+	if b.sums == 0 {
+		b.sums = d.sums
+	}
+	if b.schm == 0 {
+		b.schema, b.schm = d.schema, d.schm
+	}
+}
+
+// clientConf holds the client-wide
+// defaults and the per-bucket overrides.
+// Overrides are keyed by bucket name, so they
+// apply to every operation on that bucket,
+// whether it is made through a *Bucket
+// or directly through the *Client.
+type clientConf struct {
+	lock    sync.RWMutex
+	dflt    bucketConf
+	buckets map[string]*bucketConf
+}
+
+// conf returns the effective configuration
+// for a bucket
+func (c *Client) conf(bucket []byte) bucketConf {
+	c.cfg.lock.RLock()
+	var out bucketConf
+	if bc, ok := c.cfg.buckets[string(bucket)]; ok {
+		out = *bc
+	}
+	out.merge(&c.cfg.dflt)
+	c.cfg.lock.RUnlock()
+	return out
+}
+
+// setConf applies 'fn' to the configuration
+// of 'bucket', or to the client defaults
+// if 'bucket' is nil
+func (c *Client) setConf(bucket *string, fn func(*bucketConf)) {
+	c.cfg.lock.Lock()
+	if bucket == nil {
+		fn(&c.cfg.dflt)
+		c.cfg.lock.Unlock()
+		return
+	}
+	if c.cfg.buckets == nil {
+		c.cfg.buckets = make(map[string]*bucketConf)
+	}
+	bc, ok := c.cfg.buckets[*bucket]
+	if !ok {
+		bc = new(bucketConf)
+		c.cfg.buckets[*bucket] = bc
+	}
+	fn(bc)
+	c.cfg.lock.Unlock()
+}
@@ -0,0 +1,157 @@
+package rkive
+
+import (
+	"encoding/binary"
+	"github.com/philhofer/rkive/rpbc"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowFrame is a response that
+// fakeRiak sends after 'wait'
+type slowFrame struct {
+	protom
+	wait time.Duration
+}
+
+// fakeRiak serves one response frame for each
+// request code in 'res', and replies to pings.
+// It returns a client that dials it, a counter
+// of the connections it has accepted, and a
+// function that stops the client and the server.
+func fakeRiak(t *testing.T, res map[byte]protom) (*Client, *int32, func()) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := new(int32)
+	go func() {
+		for {
+			cn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(accepted, 1)
+			go serveFake(cn, res)
+		}
+	}()
+	c := &Client{addrs: []*net.TCPAddr{l.Addr().(*net.TCPAddr)}}
+	return c, accepted, func() { c.Close(); l.Close() }
+}
+
+func serveFake(cn net.Conn, res map[byte]protom) {
+	defer cn.Close()
+	var lead [5]byte
+	for {
+		if _, err := io.ReadFull(cn, lead[:]); err != nil {
+			return
+		}
+		body := make([]byte, binary.BigEndian.Uint32(lead[:4])-1)
+		if _, err := io.ReadFull(cn, body); err != nil {
+			return
+		}
+		// responses use the request code + 1
+		code := lead[4]
+		var msg []byte
+		if m, ok := res[code]; ok {
+			if sf, ok := m.(slowFrame); ok {
+				time.Sleep(sf.wait)
+			}
+			msg = make([]byte, m.Size())
+			m.MarshalTo(msg)
+		}
+		out := make([]byte, 5, 5+len(msg))
+		binary.BigEndian.PutUint32(out, uint32(len(msg)+1))
+		out[4] = code + 1
+		if _, err := cn.Write(append(out, msg...)); err != nil {
+			return
+		}
+	}
+}
+
+// pooledConn returns the connection in the
+// client's pool, or skips the test if connections
+// aren't pooled in this build
+func pooledConn(t *testing.T, c *Client) *conn {
+	cn, ok := c.pool.Get().(*conn)
+	if !ok || cn == nil {
+		t.Skip("connections are not pooled in this build")
+	}
+	return cn
+}
+
+func TestStreamResetsTimeout(t *testing.T) {
+	c, _, stop := fakeRiak(t, map[byte]protom{
+		17: &rpbc.RpbListKeysResp{Keys: [][]byte{[]byte("k")}, Done: &ptrTrue},
+	})
+	defer stop()
+	c.AllowListing(true)
+	ks, err := c.Bucket("b").ListKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, err := ks.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	cn := pooledConn(t, c)
+	if cn.rto != 0 {
+		t.Errorf("pooled connection has read timeout %s", cn.rto)
+	}
+	c.pool.Put(cn)
+}
+
+func TestFoldTimeout(t *testing.T) {
+	c, _, stop := fakeRiak(t, map[byte]protom{
+		40: slowFrame{&rpbc.RpbCSBucketResp{Done: &ptrTrue}, 1500 * time.Millisecond},
+	})
+	defer stop()
+	// folds without a timeout use riak's default
+	for _, opts := range []*FoldOpts{{Timeout: 5 * time.Second}, nil} {
+		it, err := c.Bucket("b").FoldWith("", "", &TestObject{}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := it.Next(); err != io.EOF {
+			t.Errorf("expected io.EOF; got %v", err)
+		}
+	}
+}
+
+func TestConnClose(t *testing.T) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	tc, err := net.DialTCP("tcp", nil, l.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	c := &Client{conns: 1}
+	cn := &conn{TCPConn: tc, parent: c}
+	cn.Close()
+	cn.Close()
+	if c.conns != 0 {
+		t.Errorf("conns: %d", c.conns)
+	}
+	// the socket itself is closed
+	srv.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := srv.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected io.EOF from the peer; got %v", err)
+	}
+}
@@ -0,0 +1,246 @@
+package rkive
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"github.com/philhofer/rkive/rpbc"
+	"io"
+)
+
+const (
+	// MetaKeyID is the usermeta key under which
+	// the ID of the key-encryption key is stored
+	// for encrypted values.
+	MetaKeyID = "rkive-kid"
+
+	// MetaDataKey is the usermeta key under which
+	// the wrapped data key is stored for encrypted
+	// values.
+	MetaDataKey = "rkive-dek"
+)
+
+var (
+	// ErrNoKeyProvider is returned when an encrypted
+	// value is read from a bucket without a KeyProvider.
+	ErrNoKeyProvider = errors.New("encrypted value and no key provider")
+
+	// ErrUnknownKey is returned by StaticKeys when
+	// asked to use a key it doesn't have.
+	ErrUnknownKey = errors.New("unknown key")
+)
+
+// A KeyProvider manages the key-encryption keys used
+// for envelope encryption. Every value is encrypted
+// with AES-GCM under a fresh data key, which is wrapped
+// by the provider and stored (along with the ID of the
+// wrapping key) in the object's usermeta. Values are
+// bound to their bucket and key, so a value copied to
+// another location fails to decrypt.
+type KeyProvider interface {
+	// CurrentKey returns the ID of the
+	// key used to wrap new data keys.
+	CurrentKey() (string, error)
+
+	// WrapKey encrypts a data key with
+	// the key-encryption key 'id'.
+	WrapKey(id string, dek []byte) ([]byte, error)
+
+	// UnwrapKey decrypts a data key that was
+	// wrapped with the key-encryption key 'id'.
+	// It must be able to unwrap data keys wrapped
+	// with any key that was ever current.
+	UnwrapKey(id string, wrapped []byte) ([]byte, error)
+}
+
+// SetEncryption sets the default KeyProvider for
+// every bucket. Values are encrypted before they are
+// written, and decrypted before they are unmarshaled.
+// Unencrypted values can still be read.
+func (c *Client) SetEncryption(kp KeyProvider) {
+	c.setConf(nil, func(bc *bucketConf) { bc.keys, bc.crypt = kp, tristate(kp != nil) })
+}
+
+// SetEncryption sets the KeyProvider for the bucket,
+// which overrides the client's KeyProvider. A nil
+// KeyProvider disables encryption for the bucket
+// even if the client encrypts by default.
+func (b *Bucket) SetEncryption(kp KeyProvider) {
+	b.c.setConf(&b.nm, func(bc *bucketConf) { bc.keys, bc.crypt = kp, tristate(kp != nil) })
+}
+
+// Rekey fetches the object at 'key' into 'o' and,
+// if it was not encrypted under the bucket's current
+// key, pushes it back so that it is re-encrypted with
+// the current key. Rekey returns whether or not the
+// object was rewritten. Use it to rotate keys.
+func (b *Bucket) Rekey(o Object, key string) (bool, error) {
+	kp := b.c.conf(ustr(b.nm)).keys
+	if kp == nil {
+		return false, ErrNoKeyProvider
+	}
+	cur, err := kp.CurrentKey()
+	if err != nil {
+		return false, err
+	}
+	err = b.Fetch(o, key)
+	if err != nil {
+		return false, err
+	}
+	if o.Info().GetMeta(MetaKeyID) == cur {
+		return false, nil
+	}
+	err = b.Push(o)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// StaticKeys is a KeyProvider backed by an in-memory
+// set of 256-bit master keys, indexed by ID. Data keys
+// are wrapped with AES-GCM. To rotate keys, add a new
+// key to Keys and make it Current; keep the old keys
+// for as long as values encrypted under them exist.
+type StaticKeys struct {
+	Current string
+	Keys    map[string][]byte
+}
+
+// CurrentKey implements KeyProvider.CurrentKey
+func (s *StaticKeys) CurrentKey() (string, error) {
+	if _, ok := s.Keys[s.Current]; !ok {
+		return "", ErrUnknownKey
+	}
+	return s.Current, nil
+}
+
+// WrapKey implements KeyProvider.WrapKey
+func (s *StaticKeys) WrapKey(id string, dek []byte) ([]byte, error) {
+	kek, ok := s.Keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return seal(kek, dek, []byte(id))
+}
+
+// UnwrapKey implements KeyProvider.UnwrapKey
+func (s *StaticKeys) UnwrapKey(id string, wrapped []byte) ([]byte, error) {
+	kek, ok := s.Keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return open(kek, wrapped, []byte(id))
+}
+
+// AES-GCM encrypt with additional data 'ad';
+// the nonce is prepended
+func seal(key []byte, v []byte, ad []byte) ([]byte, error) {
+	blk, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(blk)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(v)+gcm.Overhead())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, v, ad), nil
+}
+
+// AES-GCM decrypt with additional data 'ad'
+func open(key []byte, v []byte, ad []byte) ([]byte, error) {
+	blk, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(blk)
+	if err != nil {
+		return nil, err
+	}
+	if len(v) < gcm.NonceSize() {
+		return nil, errors.New("rkive: encrypted value too short")
+	}
+	return gcm.Open(nil, v[:gcm.NonceSize()], v[gcm.NonceSize():], ad)
+}
+
+// randomKey returns a new key for an encrypted
+// object; like a key assigned by riak, it is
+// 22 characters long
+func randomKey() (string, error) {
+	var b [16]byte
+	_, err := io.ReadFull(rand.Reader, b[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b[:]), nil
+}
+
+// additional data for a value: the length-prefixed
+// bucket, key and key-encryption key ID
+func aad(bucket []byte, key []byte, id []byte) []byte {
+	out := make([]byte, 0, len(bucket)+len(key)+len(id)+3*binary.MaxVarintLen64)
+	var lb [binary.MaxVarintLen64]byte
+	for _, f := range [][]byte{bucket, key, id} {
+		out = append(out, lb[:binary.PutUvarint(lb[:], uint64(len(f)))]...)
+		out = append(out, f...)
+	}
+	return out
+}
+
+// encrypt 'v' under a new data key and
+// append the key metadata to 'meta'
+func encrypt(v []byte, meta []*rpbc.RpbPair, kp KeyProvider, bucket []byte, key []byte) ([]byte, []*rpbc.RpbPair, error) {
+	id, err := kp.CurrentKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	dek := make([]byte, 32)
+	_, err = io.ReadFull(rand.Reader, dek)
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := kp.WrapKey(id, dek)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := seal(dek, v, aad(bucket, key, []byte(id)))
+	if err != nil {
+		return nil, nil, err
+	}
+	meta = append(meta, &rpbc.RpbPair{
+		Key:   []byte(MetaKeyID),
+		Value: []byte(id),
+	}, &rpbc.RpbPair{
+		Key:   []byte(MetaDataKey),
+		Value: []byte(base64.StdEncoding.EncodeToString(wrapped)),
+	})
+	return out, meta, nil
+}
+
+// decrypt 'v' if 'meta' says it is encrypted
+func decrypt(v []byte, meta []*rpbc.RpbPair, kp KeyProvider, bucket []byte, key []byte) ([]byte, error) {
+	id := get(&meta, ustr(MetaKeyID))
+	if id == nil {
+		return v, nil
+	}
+	if kp == nil {
+		return nil, ErrNoKeyProvider
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(string(get(&meta, ustr(MetaDataKey))))
+	if err != nil {
+		return nil, err
+	}
+	dek, err := kp.UnwrapKey(string(id), wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return open(dek, v, aad(bucket, key, id))
+}
@@ -0,0 +1,179 @@
+package rkive
+
+import (
+	"bytes"
+	"github.com/philhofer/rkive/rpbc"
+	"testing"
+)
+
+func TestEncryption(t *testing.T) {
+	keys := &StaticKeys{
+		Current: "k1",
+		Keys: map[string][]byte{
+			"k1": bytes.Repeat([]byte{1}, 32),
+			"k2": bytes.Repeat([]byte{2}, 32),
+		},
+	}
+	cf := &bucketConf{keys: keys}
+
+	plain := []byte("social security number")
+	ob := &Blob{Content: plain}
+	ob.Info().SetMeta("owner", "joe")
+	ctnt := &rpbc.RpbContent{}
+	b, k := []byte("b"), []byte("k1")
+	err := writeContent(ob, ctnt, b, k, cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(ctnt.Value, plain) {
+		t.Fatal("value was not encrypted")
+	}
+	if string(get(&ctnt.Usermeta, ustr(MetaKeyID))) != "k1" {
+		t.Errorf("key id: %q", get(&ctnt.Usermeta, ustr(MetaKeyID)))
+	}
+
+	// rotate; old values remain readable
+	keys.Current = "k2"
+	out := &Blob{}
+	out.Info().bucket, out.Info().key = b, k
+	err = readContent(out, ctnt, cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Content, plain) {
+		t.Errorf("got %q", out.Content)
+	}
+	if out.Info().GetMeta("owner") != "joe" || out.Info().GetMeta(MetaKeyID) != "k1" {
+		t.Errorf("meta: %v", out.Info().Metas())
+	}
+
+	// re-writing replaces the key metadata
+	err = writeContent(out, ctnt, b, k, cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ctnt.Usermeta) != 3 || string(get(&ctnt.Usermeta, ustr(MetaKeyID))) != "k2" {
+		t.Errorf("usermeta after rotation: %v", ctnt.Usermeta)
+	}
+
+	// values copied to another key or
+	// bucket fail to decrypt
+	for _, path := range [][2]string{{"b", "k2"}, {"c", "k1"}, {"", "bk1"}} {
+		moved := &Blob{}
+		moved.Info().bucket, moved.Info().key = []byte(path[0]), []byte(path[1])
+		if err = readContent(moved, ctnt, cf); err == nil {
+			t.Errorf("value moved to %s/%s was decrypted", path[0], path[1])
+		}
+	}
+
+	// ...as do values that name a different
+	// key id, even if it has the same key
+	keys.Keys["k3"] = keys.Keys["k2"]
+	swapped := &rpbc.RpbContent{Value: ctnt.Value}
+	for _, p := range ctnt.Usermeta {
+		if string(p.Key) == MetaKeyID {
+			p = &rpbc.RpbPair{Key: p.Key, Value: []byte("k3")}
+		}
+		swapped.Usermeta = append(swapped.Usermeta, p)
+	}
+	if err = readContent(out, swapped, cf); err == nil {
+		t.Error("value with a substituted key id was decrypted")
+	}
+
+	// managed metadata can't be set by hand
+	if out.Info().AddMeta(MetaKeyID, "k3") {
+		t.Error("AddMeta accepted a reserved key")
+	}
+	reserved := &Blob{}
+	if reserved.Info().SetMeta(MetaKeyID, "k3") || reserved.Info().GetMeta(MetaKeyID) != "" {
+		t.Error("SetMeta accepted a reserved key")
+	}
+
+	// encrypted values can't be read without keys
+	err = readContent(out, ctnt, &bucketConf{})
+	if err != ErrNoKeyProvider {
+		t.Errorf("expected ErrNoKeyProvider; got %v", err)
+	}
+
+	// plaintext values can be read with keys
+	err = writeContent(&Blob{Content: plain}, ctnt, nil, nil, &bucketConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = readContent(out, ctnt, cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Content, plain) {
+		t.Errorf("got %q", out.Content)
+	}
+}
+
+func TestEncryptCompressed(t *testing.T) {
+	keys := &StaticKeys{
+		Current: "k1",
+		Keys:    map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)},
+	}
+	cd, err := compression("gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf := &bucketConf{keys: keys, codec: cd, cmin: 1}
+
+	plain := bytes.Repeat([]byte("compress me! "), 100)
+	ctnt := &rpbc.RpbContent{}
+	b, k := []byte("b"), []byte("k")
+	err = writeContent(&Blob{Content: plain}, ctnt, b, k, cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the stored bytes are ciphertext,
+	// not gzip, so the encoding mustn't
+	// be advertised
+	if ctnt.ContentEncoding != nil {
+		t.Errorf("ContentEncoding %q", ctnt.ContentEncoding)
+	}
+	if string(get(&ctnt.Usermeta, ustr(MetaCodec))) != "gzip" {
+		t.Errorf("usermeta: %v", ctnt.Usermeta)
+	}
+
+	out := &Blob{}
+	out.Info().bucket, out.Info().key = b, k
+	err = readContent(out, ctnt, cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Content, plain) {
+		t.Error("value didn't round-trip")
+	}
+	if out.Info().ContentEncoding() != "gzip" {
+		t.Errorf("ContentEncoding: %q", out.Info().ContentEncoding())
+	}
+
+	// written back without keys, the value
+	// is compressed and the encoding is
+	// advertised again
+	err = writeContent(out, ctnt, b, k, &bucketConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ctnt.ContentEncoding) != "gzip" || get(&ctnt.Usermeta, ustr(MetaCodec)) != nil {
+		t.Errorf("ContentEncoding %q; usermeta %v", ctnt.ContentEncoding, ctnt.Usermeta)
+	}
+}
+
+func TestBucketEncryptionOff(t *testing.T) {
+	c := &Client{}
+	c.SetEncryption(&StaticKeys{
+		Current: "k1",
+		Keys:    map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)},
+	})
+	c.Bucket("public").SetEncryption(nil)
+
+	if cf := c.conf([]byte("public")); cf.keys != nil {
+		t.Error("bucket encryption should be off")
+	}
+	if cf := c.conf([]byte("other")); cf.keys == nil {
+		t.Error("other buckets should use the client keys")
+	}
+}
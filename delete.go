@@ -55,3 +55,63 @@ func (c *Client) Delete(o Object, opts *DelOpts) error {
 	_, err := c.req(req, 13, nil)
 	return err
 }
+
+// DeleteKey deletes the object at the given bucket and key
+// without requiring a local copy of the object. It fetches
+// the object's current vclock first so that the delete
+// descends from every existing sibling. DeleteKey returns
+// nil if the object doesn't exist or has already been deleted.
+func (c *Client) DeleteKey(bucket string, key string, opts *DelOpts) error {
+	req := &rpbc.RpbGetReq{
+		Bucket:        []byte(bucket),
+		Key:           []byte(key),
+		Timeout:       &dfltreq,
+		Head:          &ptrTrue,
+		Deletedvclock: &ptrTrue,
+	}
+	res := gresPop()
+	code, err := c.req(req, 9, res)
+	if err != nil {
+		gresPush(res)
+		if err == ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	if code != 10 {
+		gresPush(res)
+		return ErrUnexpectedResponse
+	}
+	live := false
+	for _, ctnt := range res.Content {
+		if !ctnt.GetDeleted() {
+			live = true
+			break
+		}
+	}
+	if !live {
+		gresPush(res)
+		return nil
+	}
+	dreq := &rpbc.RpbDelReq{
+		Bucket: req.Bucket,
+		Key:    req.Key,
+		Vclock: append([]byte(nil), res.Vclock...),
+	}
+	gresPush(res)
+	parseDelOpts(opts, dreq)
+	_, err = c.req(dreq, 13, nil)
+	return err
+}
+
+// Resurrect re-creates an object at the location
+// of a tombstone, using the tombstone's vclock, so
+// that the new value supersedes the delete rather
+// than becoming a sibling of it. The bucket, key,
+// and vclock of 'o' are overwritten.
+func (c *Client) Resurrect(o Object, t *ErrTombstone, opts *WriteOpts) error {
+	o.Info().bucket = append(o.Info().bucket[0:0], t.info.bucket...)
+	o.Info().key = append(o.Info().key[0:0], t.info.key...)
+	o.Info().vclock = append(o.Info().vclock[0:0], t.info.vclock...)
+	return c.Store(o, opts)
+}
@@ -3,6 +3,8 @@
 package rkive
 
 import (
+	"bytes"
+	"errors"
 	check "gopkg.in/check.v1"
 	"time"
 )
@@ -30,3 +32,80 @@ func (s *riakSuite) TestDelete(c *check.C) {
 
 	s.runtime += time.Since(startt)
 }
+
+func (s *riakSuite) TestDeleteKey(c *check.C) {
+	startt := time.Now()
+	ob := &TestObject{
+		Data: []byte("Blah."),
+	}
+
+	err := s.cl.New(ob, "testbucket", nil, nil)
+	if err != nil {
+		c.Fatal(err)
+	}
+
+	err = s.cl.DeleteKey("testbucket", ob.Info().Key(), nil)
+	if err != nil {
+		c.Fatal(err)
+	}
+
+	// deleting twice is not an error
+	err = s.cl.DeleteKey("testbucket", ob.Info().Key(), nil)
+	if err != nil {
+		c.Fatal(err)
+	}
+
+	nob := &TestObject{}
+	err = s.cl.Fetch(nob, "testbucket", ob.Info().Key(), nil)
+	if err != ErrNotFound && !errors.Is(err, ErrDeleted) {
+		c.Fatalf("Expected ErrNotFound or ErrDeleted; got %v", err)
+	}
+	s.runtime += time.Since(startt)
+}
+
+func (s *riakSuite) TestResurrect(c *check.C) {
+	startt := time.Now()
+	ob := &TestObject{
+		Data: []byte("Blah."),
+	}
+
+	err := s.cl.New(ob, "testbucket", nil, nil)
+	if err != nil {
+		c.Fatal(err)
+	}
+	err = s.cl.Delete(ob, nil)
+	if err != nil {
+		c.Fatal(err)
+	}
+
+	nob := &TestObject{}
+	err = s.cl.Fetch(nob, "testbucket", ob.Info().Key(), nil)
+	if err == ErrNotFound {
+		c.Skip("tombstone was reaped immediately (delete_mode = immediate)")
+	}
+	tomb, ok := err.(*ErrTombstone)
+	if !ok {
+		c.Fatalf("Expected *ErrTombstone; got %v", err)
+	}
+	if !errors.Is(err, ErrDeleted) {
+		c.Error("*ErrTombstone should match ErrDeleted")
+	}
+	if tomb.Info().Vclock() == "" {
+		c.Fatal("tombstone has no vclock")
+	}
+
+	nob.Data = []byte("Back again.")
+	err = s.cl.Resurrect(nob, tomb, nil)
+	if err != nil {
+		c.Fatal(err)
+	}
+
+	err = s.cl.Fetch(ob, "testbucket", tomb.Key, nil)
+	if err != nil {
+		c.Fatal(err)
+	}
+	if !bytes.Equal(ob.Data, nob.Data) {
+		c.Errorf("Expected %q; got %q", nob.Data, ob.Data)
+	}
+	s.runtime += time.Since(startt)
+}
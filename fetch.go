@@ -22,7 +22,9 @@ var (
 
 	// ErrDeleted is returned
 	// when the object has been marked
-	// as deleted, but has not yet been reaped
+	// as deleted, but has not yet been reaped.
+	// Reads return it wrapped in an *ErrTombstone,
+	// so use errors.Is to test for it.
 	ErrDeleted = errors.New("object deleted")
 
 	// default timeout on a request is 500ms
@@ -37,6 +39,43 @@ func init() {
 	gresPool.New = func() interface{} { return &rpbc.RpbGetResp{} }
 }
 
+// ErrTombstone is the type of error returned
+// when a read finds a tombstone (an object that
+// has been deleted but not yet reaped). Its Info
+// carries the tombstone's vclock, which can be used
+// to re-create the object without producing a
+// sibling. (See: Resurrect) ErrTombstone matches
+// ErrDeleted under errors.Is.
+type ErrTombstone struct {
+	Bucket string
+	Key    string
+	info   Info
+}
+
+func (t *ErrTombstone) Error() string { return ErrDeleted.Error() }
+
+// Is implements errors.Is
+func (t *ErrTombstone) Is(err error) bool { return err == ErrDeleted }
+
+// Info returns the metadata of the tombstone.
+func (t *ErrTombstone) Info() *Info { return &t.info }
+
+// tombstone sets the path and vclock of 'o'
+// and returns an *ErrTombstone
+func tombstone(o Object, bucket []byte, key []byte, vclock []byte) *ErrTombstone {
+	o.Info().key = append(o.Info().key[0:0], key...)
+	o.Info().bucket = append(o.Info().bucket[0:0], bucket...)
+	o.Info().vclock = append(o.Info().vclock[0:0], vclock...)
+	t := &ErrTombstone{
+		Bucket: string(bucket),
+		Key:    string(key),
+	}
+	t.info.key = append(t.info.key, key...)
+	t.info.bucket = append(t.info.bucket, bucket...)
+	t.info.vclock = append(t.info.vclock, vclock...)
+	return t
+}
+
 // pop response from cache
 func gresPop() *rpbc.RpbGetResp {
 	return gresPool.Get().(*rpbc.RpbGetResp)
@@ -90,6 +129,14 @@ func parseROpts(req *rpbc.RpbGetReq, opts *ReadOpts) {
 // if the object supplied does not know how to unmarshal
 // the bytes returned from riak.
 func (c *Client) Fetch(o Object, bucket string, key string, opts *ReadOpts) error {
+	return c.fetch(o, bucket, key, opts, true)
+}
+
+// fetch is Fetch; upgraded objects are
+// only written back if 'wb' is set, since
+// writes that fetch in order to resolve
+// siblings are about to write anyway
+func (c *Client) fetch(o Object, bucket string, key string, opts *ReadOpts, wb bool) error {
 	// make request object
 	req := &rpbc.RpbGetReq{
 		Bucket: []byte(bucket),
@@ -97,40 +144,56 @@ func (c *Client) Fetch(o Object, bucket string, key string, opts *ReadOpts) erro
 	}
 	// set 500ms reqeust timeout
 	req.Timeout = &dfltreq
+	// return the vclock of tombstones
+	req.Deletedvclock = &ptrTrue
 	// get opts
 	parseROpts(req, opts)
 
 	res := gresPop()
 	rescode, err := c.req(req, 9, res)
 	if err != nil {
+		gresPush(res)
 		return err
 	}
 	if rescode != 10 {
+		gresPush(res)
 		return ErrUnexpectedResponse
 	}
-	// this *should* be handled by req(),
-	// but just in case:
+	// a tombstone has a vclock but no content
 	if len(res.GetContent()) == 0 {
+		if len(res.Vclock) > 0 {
+			err = tombstone(o, req.Bucket, req.Key, res.Vclock)
+			gresPush(res)
+			return err
+		}
+		gresPush(res)
 		return ErrNotFound
 	}
+	cf := c.conf(req.Bucket)
 	if len(res.GetContent()) > 1 {
-		// merge objects; repair happens
-		// on write to prevent sibling
+		// resolve or merge objects; repair
+		// happens on write to prevent sibling
 		// explosion
-		if om, ok := o.(ObjectM); ok {
-			om.Info().key = append(om.Info().key[0:0], req.Key...)
-			om.Info().bucket = append(om.Info().bucket[0:0], req.Bucket...)
-			om.Info().vclock = append(om.Info().vclock[0:0], res.Vclock...)
-			return handleMerge(om, res.Content)
-		} else {
-			return handleMultiple(len(res.Content), key, bucket)
+		err = c.handleSiblings(o, req.Bucket, req.Key, res)
+		gresPush(res)
+		if err == nil && wb {
+			c.writeBack(o, &cf)
 		}
+		return err
+	}
+	if res.Content[0].GetDeleted() {
+		err = tombstone(o, req.Bucket, req.Key, res.Vclock)
+		gresPush(res)
+		return err
 	}
-	err = readContent(o, res.Content[0])
 	o.Info().key = append(o.Info().key[0:0], req.Key...)
 	o.Info().bucket = append(o.Info().bucket[0:0], req.Bucket...)
+	err = readContent(o, res.Content[0], &cf)
 	o.Info().vclock = append(o.Info().vclock[0:0], res.Vclock...)
 	gresPush(res)
+	if err == nil && wb {
+		c.writeBack(o, &cf)
+	}
 	return err
 }
 
@@ -149,37 +212,59 @@ func (c *Client) Update(o Object, opts *ReadOpts) (bool, error) {
 		Timeout:    &dfltreq,
 		IfModified: o.Info().vclock,
 	}
+	req.Deletedvclock = &ptrTrue
 
 	parseROpts(req, opts)
 
 	res := gresPop()
 	rescode, err := c.req(req, 9, res)
 	if err != nil {
+		gresPush(res)
 		return false, err
 	}
 	if rescode != 10 {
+		gresPush(res)
 		return false, ErrUnexpectedResponse
 	}
 	if res.Unchanged != nil && *res.Unchanged {
+		gresPush(res)
 		return false, nil
 	}
 	if len(res.GetContent()) == 0 {
+		if len(res.Vclock) > 0 {
+			err = tombstone(o, req.Bucket, req.Key, res.Vclock)
+			gresPush(res)
+			return true, err
+		}
+		gresPush(res)
 		return false, ErrNotFound
 	}
+	cf := c.conf(req.Bucket)
 	if len(res.GetContent()) > 1 {
-		if om, ok := o.(ObjectM); ok {
-			// like Fetch, we merge the results
-			// here and hope for reconciliation
-			// on write
-			om.Info().vclock = append(o.Info().vclock[0:0], res.GetVclock()...)
-			err = handleMerge(om, res.Content)
-			return true, err
+		// like Fetch, we resolve or merge the
+		// results here and hope for reconciliation
+		// on write
+		err = c.handleSiblings(o, req.Bucket, req.Key, res)
+		gresPush(res)
+		if _, ok := err.(*ErrMultipleResponses); ok {
+			return false, err
 		}
-		return false, handleMultiple(len(res.Content), o.Info().Key(), o.Info().Bucket())
+		if err == nil {
+			c.writeBack(o, &cf)
+		}
+		return true, err
+	}
+	if res.Content[0].GetDeleted() {
+		err = tombstone(o, req.Bucket, req.Key, res.Vclock)
+		gresPush(res)
+		return true, err
 	}
-	err = readContent(o, res.Content[0])
+	err = readContent(o, res.Content[0], &cf)
 	o.Info().vclock = append(o.Info().vclock[0:0], res.Vclock...)
 	gresPush(res)
+	if err == nil {
+		c.writeBack(o, &cf)
+	}
 	return true, err
 }
 
@@ -193,6 +278,7 @@ func (c *Client) FetchHead(bucket string, key string) (*Info, error) {
 		Timeout: &dfltreq,
 		Head:    &ptrTrue,
 	}
+	req.Deletedvclock = &ptrTrue
 	res := gresPop()
 	rescode, err := c.req(req, 9, res)
 	if err != nil {
@@ -203,9 +289,13 @@ func (c *Client) FetchHead(bucket string, key string) (*Info, error) {
 		gresPush(res)
 		return nil, ErrUnexpectedResponse
 	}
-	// NotFound is supposed to be handled by
-	// c.req, but just in case:
+	bl := &Blob{}
 	if len(res.Content) == 0 {
+		if len(res.Vclock) > 0 {
+			err = tombstone(bl, req.Bucket, req.Key, res.Vclock)
+			gresPush(res)
+			return nil, err
+		}
 		gresPush(res)
 		return nil, ErrNotFound
 	}
@@ -213,7 +303,11 @@ func (c *Client) FetchHead(bucket string, key string) (*Info, error) {
 		gresPush(res)
 		return nil, handleMultiple(len(res.Content), key, bucket)
 	}
-	bl := &Blob{}
+	if res.Content[0].GetDeleted() {
+		err = tombstone(bl, req.Bucket, req.Key, res.Vclock)
+		gresPush(res)
+		return nil, err
+	}
 	readHeader(bl, res.Content[0])
 	bl.RiakInfo.vclock = append(bl.Info().vclock[0:0], res.Vclock...)
 	bl.RiakInfo.key = append(bl.Info().key[0:0], req.Key...)
@@ -237,6 +331,7 @@ func (c *Client) PullHead(o Object) error {
 		Head:       &ptrTrue,
 		IfModified: o.Info().vclock,
 	}
+	req.Deletedvclock = &ptrTrue
 	res := gresPop()
 	code, err := c.req(req, 9, res)
 	if err != nil {
@@ -244,6 +339,7 @@ func (c *Client) PullHead(o Object) error {
 		return err
 	}
 	if code != 10 {
+		gresPush(res)
 		return ErrUnexpectedResponse
 	}
 	if res.GetUnchanged() {
@@ -251,12 +347,23 @@ func (c *Client) PullHead(o Object) error {
 		return nil
 	}
 	if len(res.Content) == 0 {
+		if len(res.Vclock) > 0 {
+			err = tombstone(o, req.Bucket, req.Key, res.Vclock)
+			gresPush(res)
+			return err
+		}
+		gresPush(res)
 		return ErrNotFound
 	}
 	if len(res.Content) > 1 {
 		gresPush(res)
 		return handleMultiple(len(res.Content), o.Info().Key(), o.Info().Bucket())
 	}
+	if res.Content[0].GetDeleted() {
+		err = tombstone(o, req.Bucket, req.Key, res.Vclock)
+		gresPush(res)
+		return err
+	}
 	readHeader(o, res.Content[0])
 	o.Info().vclock = append(o.Info().vclock[0:0], res.Vclock...)
 	gresPush(res)
@@ -0,0 +1,167 @@
+package rkive
+
+import (
+	"errors"
+	"github.com/philhofer/rkive/rpbc"
+	"io"
+	"time"
+)
+
+// FoldOpts are options for folds.
+type FoldOpts struct {
+	MaxResults int           // maximum number of objects (0 = no limit)
+	After      Continuation  // continuation from a previous fold
+	Timeout    time.Duration // timeout, on the server and for each response (0 = default)
+}
+
+// FoldIter is an iterator over the objects
+// in a range of keys. Objects are decoded as
+// each response frame arrives. A FoldIter holds
+// a connection until it has been read to the end
+// or closed.
+type FoldIter struct {
+	c    *Client
+	cf   bucketConf
+	nm   []byte
+	o    Duplicator
+	s    *streamRes
+	res  rpbc.RpbCSBucketResp
+	objs []*rpbc.RpbIndexObject
+	cont Continuation
+	done bool
+	err  error
+}
+
+// Fold returns every object in the bucket with a
+// key between 'start' and 'end', inclusive, in a single
+// pass. If 'end' is empty, the fold continues to the end
+// of the bucket. Every object returned is created with
+// o.NewEmpty(). Objects with siblings are merged or resolved
+// in the same way as Fetch does. Deleted objects are skipped.
+func (b *Bucket) Fold(start string, end string, o Duplicator) (*FoldIter, error) {
+	return b.FoldWith(start, end, o, nil)
+}
+
+// FoldWith is like Fold, but it takes options.
+// Folds with MaxResults set can be continued
+// with the iterator's Continuation.
+func (b *Bucket) FoldWith(start string, end string, o Duplicator, opts *FoldOpts) (*FoldIter, error) {
+	req := &rpbc.RpbCSBucketReq{
+		Bucket:    []byte(b.nm),
+		StartKey:  []byte(start),
+		StartIncl: &ptrTrue,
+	}
+	if end != "" {
+		req.EndKey = []byte(end)
+		req.EndIncl = &ptrTrue
+	}
+	var rto time.Duration
+	if opts != nil {
+		if opts.MaxResults > 0 {
+			mx := uint32(opts.MaxResults)
+			req.MaxResults = &mx
+		}
+		if opts.After != "" {
+			req.Continuation = []byte(opts.After)
+		}
+		if opts.Timeout > 0 {
+			ms := uint32(opts.Timeout / time.Millisecond)
+			req.Timeout = &ms
+			rto = opts.Timeout
+		}
+	}
+	s, err := b.c.streamReqTimeout(req, 40, streamTimeout(rto))
+	if err != nil {
+		return nil, err
+	}
+	return &FoldIter{
+		c:  b.c,
+		cf: b.c.conf(req.Bucket),
+		nm: req.Bucket,
+		o:  o,
+		s:  s,
+	}, nil
+}
+
+// Next returns the next object in the fold. Errors
+// decoding an individual object are returned in the
+// Error field of the result, and the fold can continue.
+// Next returns io.EOF once every object has been
+// returned, or any error reading the stream.
+func (f *FoldIter) Next() (*AsyncFetch, error) {
+	for {
+		for len(f.objs) > 0 {
+			obj := f.objs[0]
+			f.objs = f.objs[1:]
+			r, ok := f.decode(obj)
+			if ok {
+				return r, nil
+			}
+		}
+		if f.done {
+			return nil, f.err
+		}
+		f.res.Reset()
+		var code byte
+		f.done, code, f.err = f.s.unmarshal(&f.res)
+		if f.err == nil && code != 41 {
+			if !f.done {
+				f.s.abort()
+			}
+			f.done, f.err = true, ErrUnexpectedResponse
+		}
+		if f.err != nil {
+			f.done = true
+			return nil, f.err
+		}
+		if len(f.res.Continuation) > 0 {
+			f.cont = Continuation(f.res.Continuation)
+		}
+		if f.done {
+			f.err = io.EOF
+		}
+		f.objs = f.res.Objects
+	}
+}
+
+// decode one object; returns false
+// for objects that should be skipped
+func (f *FoldIter) decode(obj *rpbc.RpbIndexObject) (*AsyncFetch, bool) {
+	gr := obj.Object
+	if gr == nil || len(gr.Content) == 0 {
+		return nil, false
+	}
+	ob := f.o.NewEmpty()
+	ob.Info().key = append(ob.Info().key[0:0], obj.Key...)
+	ob.Info().bucket = append(ob.Info().bucket[0:0], f.nm...)
+	var err error
+	if len(gr.Content) > 1 {
+		err = f.c.handleSiblings(ob, f.nm, obj.Key, gr)
+	} else {
+		err = readContent(ob, gr.Content[0], &f.cf)
+		ob.Info().vclock = append(ob.Info().vclock[0:0], gr.Vclock...)
+	}
+	if errors.Is(err, ErrDeleted) {
+		return nil, false
+	}
+	if err == nil {
+		f.c.writeBack(ob, &f.cf)
+	}
+	return &AsyncFetch{Key: string(obj.Key), Value: ob, Error: err}, true
+}
+
+// Continuation returns the token for continuing a
+// fold with MaxResults set, once it has been read
+// to the end. It is empty if there are no more objects.
+func (f *FoldIter) Continuation() Continuation { return f.cont }
+
+// Close stops the fold. It is safe to call
+// Close more than once, and after the fold
+// has been read to the end.
+func (f *FoldIter) Close() {
+	if !f.done {
+		f.s.abort()
+		f.done, f.err = true, io.EOF
+	}
+	f.objs = nil
+}
@@ -0,0 +1,75 @@
+// +build riak
+
+package rkive
+
+import (
+	check "gopkg.in/check.v1"
+	"io"
+	"time"
+)
+
+func (s *riakSuite) TestFold(c *check.C) {
+	startt := time.Now()
+	bucket := s.cl.Bucket("testfold")
+	for _, k := range []string{"f1", "f2", "f3", "g1"} {
+		err := bucket.Overwrite(&TestObject{Data: []byte(k)}, k)
+		if err != nil {
+			c.Fatal(err)
+		}
+	}
+
+	it, err := bucket.Fold("f1", "f3", &TestObject{})
+	if err != nil {
+		c.Fatal(err)
+	}
+	found := make(map[string]bool)
+	for {
+		r, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.Fatal(err)
+		}
+		if r.Error != nil {
+			c.Fatal(r.Error)
+		}
+		data := string(r.Value.(*TestObject).Data)
+		if data != r.Key || r.Value.Info().Key() != r.Key {
+			c.Errorf("key %q has data %q", r.Key, data)
+		}
+		found[r.Key] = true
+	}
+	if len(found) != 3 || !found["f1"] || !found["f2"] || !found["f3"] {
+		c.Errorf("expected f1, f2 and f3; got %v", found)
+	}
+
+	// page through the bucket
+	var opts FoldOpts
+	opts.MaxResults = 2
+	n := 0
+	for {
+		it, err = bucket.FoldWith("", "", &TestObject{}, &opts)
+		if err != nil {
+			c.Fatal(err)
+		}
+		for {
+			_, err := it.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				c.Fatal(err)
+			}
+			n++
+		}
+		opts.After = it.Continuation()
+		if opts.After == "" {
+			break
+		}
+	}
+	if n != 4 {
+		c.Errorf("expected 4 objects; got %d", n)
+	}
+	s.runtime += time.Since(startt)
+}
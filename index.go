@@ -2,9 +2,7 @@ package rkive
 
 import (
 	"bytes"
-	"github.com/philhofer/rkive/rpbc"
 	"io"
-	"strconv"
 	"sync"
 )
 
@@ -14,8 +12,20 @@ type IndexQueryRes struct {
 	ftchd  int
 	bucket []byte
 	keys   [][]byte
+	cont   Continuation
+	terms  []IndexEntry
 }
 
+// Entries returns the keys in the response along
+// with the index terms that they matched. Entries
+// is only populated for queries with ReturnTerms set.
+func (i *IndexQueryRes) Entries() []IndexEntry { return i.terms }
+
+// Continuation returns the token for the next
+// page of results, or the empty Continuation if
+// there are no more results. (See: IndexQuery)
+func (i *IndexQueryRes) Continuation() Continuation { return i.cont }
+
 // Contains returns whether or not the query
 // response contains this particular key
 func (i *IndexQueryRes) Contains(key string) bool {
@@ -80,11 +90,13 @@ search:
 }
 
 // AsyncFetch represents the output of an
-// asynchronous fetch operation. 'Value' is
+// asynchronous fetch operation. 'Key' is the
+// key that was fetched. 'Value' is
 // never nil, but 'Error' may or may not be nil.
 // If 'Error' is non-nil, then 'Value' is usually
 // the zero value of the underlying object.
 type AsyncFetch struct {
+	Key   string
 	Value Object
 	Error error
 }
@@ -95,6 +107,7 @@ type AsyncFetch struct {
 // all the objects have been returned. Objects are fetched
 // asynchronously. The (underlying) type of every object returned in each
 // AsyncFetch is the same as returned from o.NewEmpty().
+// (See FetchContext for a cancellable alternative.)
 func (i *IndexQueryRes) FetchAsync(o Duplicator, procs int) <-chan *AsyncFetch {
 	nw := procs
 	if i.Len() < nw || nw <= 0 {
@@ -114,7 +127,7 @@ func (i *IndexQueryRes) FetchAsync(o Duplicator, procs int) <-chan *AsyncFetch {
 			for key := range ks {
 				ob := o.NewEmpty()
 				err := i.c.Fetch(ob, string(i.bucket), key, nil)
-				outs <- &AsyncFetch{Value: ob, Error: err}
+				outs <- &AsyncFetch{Key: key, Value: ob, Error: err}
 			}
 			wg.Done()
 		}(keys, outs, o, wg)
@@ -138,102 +151,23 @@ func (i *IndexQueryRes) FetchAsync(o Duplicator, procs int) <-chan *AsyncFetch {
 
 // IndexLookup returns the keys that match the index-value pair specified. You
 // can specify the maximum number of returned keys ('max'). Index queries are
-// performed in "streaming" mode.
+// performed in "streaming" mode, but every key is buffered in the result.
+// (See IndexQuery and IndexStream for paginated and streaming queries.)
 func (c *Client) IndexLookup(bucket string, index string, value string, max *int) (*IndexQueryRes, error) {
-	bckt := []byte(bucket)
-	idx := make([]byte, len(index)+4)
-	copy(idx[0:], index)
-	copy(idx[len(index):], []byte("_bin"))
-	kv := []byte(value)
-	var qtype rpbc.RpbIndexReq_IndexQueryType = 0
-	req := &rpbc.RpbIndexReq{
-		Bucket: bckt,
-		Index:  idx,
-		Key:    kv,
-		Qtype:  &qtype,
-		Stream: &ptrTrue,
-	}
-
+	q := c.Query(bucket).Match(index, value)
 	if max != nil {
-		mxr := uint32(*max)
-		req.MaxResults = &mxr
+		q.MaxResults(*max)
 	}
-
-	queryres := &IndexQueryRes{
-		c:      c,
-		bucket: bckt,
-	}
-
-	res := &rpbc.RpbIndexResp{}
-
-	// make a stream request
-	stream, err := c.streamReq(req, 25)
-	if err != nil {
-		return nil, err
-	}
-
-	// Retrieve streaming responses
-	done := false
-	for !done {
-		var code byte
-		done, code, err = stream.unmarshal(res)
-		if err != nil {
-			return queryres, err
-		}
-		if code != 26 {
-			return queryres, ErrUnexpectedResponse
-		}
-
-		queryres.keys = append(queryres.keys, res.Keys...)
-		res.Reset()
-	}
-	return queryres, nil
+	return q.Run()
 }
 
 // IndexRange returns the keys that match the index range query. You can specify
 // the maximum number of returned results ('max'). Index queries are performed in
-// "streaming" mode.
+// "streaming" mode, but every key is buffered in the result. (See: IndexLookup)
 func (c *Client) IndexRange(bucket string, index string, min int64, max int64, maxret *int) (*IndexQueryRes, error) {
-	bckt := []byte(bucket)
-	idx := make([]byte, len(index)+4)
-	copy(idx[0:], index)
-	copy(idx[len(index):], []byte("_int"))
-	var qtype rpbc.RpbIndexReq_IndexQueryType = 1
-	req := &rpbc.RpbIndexReq{
-		Bucket:   bckt,
-		Index:    idx,
-		Qtype:    &qtype,
-		Stream:   &ptrTrue,
-		RangeMin: strconv.AppendInt([]byte{}, min, 10),
-		RangeMax: strconv.AppendInt([]byte{}, max, 10),
-	}
+	q := c.Query(bucket).RangeInt(index, min, max)
 	if maxret != nil {
-		msr := uint32(*maxret)
-		req.MaxResults = &msr
-	}
-
-	queryres := &IndexQueryRes{
-		bucket: bckt,
-	}
-
-	res := &rpbc.RpbIndexResp{}
-	stream, err := c.streamReq(req, 25)
-	if err != nil {
-		return nil, err
-	}
-
-	done := false
-	for !done {
-		var code byte
-		done, code, err = stream.unmarshal(res)
-		if err != nil {
-			return queryres, err
-		}
-		if code != 26 {
-			return queryres, ErrUnexpectedResponse
-		}
-		queryres.keys = append(queryres.keys, res.Keys...)
-		res.Reset()
+		q.MaxResults(*maxret)
 	}
-	return queryres, nil
+	return q.Run()
 }
@@ -3,7 +3,10 @@
 package rkive
 
 import (
+	"context"
+	"github.com/philhofer/rkive/tuple"
 	check "gopkg.in/check.v1"
+	"io"
 	"time"
 )
 
@@ -129,3 +132,295 @@ func (s *riakSuite) TestIndexRange(c *check.C) {
 	c.Logf("Found %d keys.", res.Len())
 	s.runtime += time.Since(startt)
 }
+
+func (s *riakSuite) TestIndexQueryPages(c *check.C) {
+	startt := time.Now()
+	bucket := s.cl.Bucket("testbucket")
+	for i := 0; i < 5; i++ {
+		ob := &TestObject{Data: []byte("page")}
+		ob.Info().AddIndex("pageIdx", "paged")
+		err := bucket.New(ob, nil)
+		if err != nil {
+			c.Fatal(err)
+		}
+	}
+
+	q := bucket.Query().Match("pageIdx", "paged").MaxResults(2)
+	seen := make(map[string]bool)
+	for {
+		res, err := q.Run()
+		if err != nil {
+			c.Fatal(err)
+		}
+		if res.Len() > 2 {
+			c.Fatalf("page of %d results with MaxResults(2)", res.Len())
+		}
+		for _, k := range res.Keys() {
+			if seen[k] {
+				c.Errorf("key %q returned twice", k)
+			}
+			seen[k] = true
+		}
+		if res.Continuation() == "" {
+			break
+		}
+		q.After(res.Continuation())
+	}
+	if len(seen) < 5 {
+		c.Errorf("expected at least 5 keys; got %d", len(seen))
+	}
+	s.runtime += time.Since(startt)
+}
+
+func (s *riakSuite) TestIndexQueryTerms(c *check.C) {
+	startt := time.Now()
+	bucket := s.cl.Bucket("testbucket")
+	ob := &TestObject{Data: []byte("termed")}
+	ob.Info().AddIndexInt("termIdx", 1234)
+	err := bucket.New(ob, nil)
+	if err != nil {
+		c.Fatal(err)
+	}
+
+	res, err := bucket.Query().RangeInt("termIdx", 1000, 2000).ReturnTerms(true).Run()
+	if err != nil {
+		c.Fatal(err)
+	}
+	found := false
+	for _, e := range res.Entries() {
+		if e.IntTerm < 1000 || e.IntTerm > 2000 {
+			c.Errorf("term %d out of range", e.IntTerm)
+		}
+		if e.Key == ob.Info().Key() {
+			found = e.IntTerm == 1234
+		}
+	}
+	if !found {
+		c.Error("object not found with the right term")
+	}
+	s.runtime += time.Since(startt)
+}
+
+func (s *riakSuite) TestIndexQueryRegex(c *check.C) {
+	startt := time.Now()
+	bucket := s.cl.Bucket("testbucket")
+	for _, nm := range []string{"alice", "bob", "brian", "carol"} {
+		ob := &TestObject{Data: []byte(nm)}
+		ob.Info().AddIndex("nameIdx", nm)
+		err := bucket.New(ob, nil)
+		if err != nil {
+			c.Fatal(err)
+		}
+	}
+
+	res, err := bucket.Query().Range("nameIdx", "a", "c").TermRegex("^b").ReturnTerms(true).Run()
+	if err != nil {
+		c.Fatal(err)
+	}
+	if len(res.Entries()) < 2 {
+		c.Errorf("expected at least 2 entries; got %d", len(res.Entries()))
+	}
+	for _, e := range res.Entries() {
+		if e.Term != "bob" && e.Term != "brian" {
+			c.Errorf("unexpected term %q", e.Term)
+		}
+	}
+	s.runtime += time.Since(startt)
+}
+
+func (s *riakSuite) TestKeyRange(c *check.C) {
+	startt := time.Now()
+	bucket := s.cl.Bucket("testkeyrange")
+	for _, k := range []string{"k1", "k2", "k3", "x1"} {
+		err := bucket.Overwrite(&TestObject{Data: []byte(k)}, k)
+		if err != nil {
+			c.Fatal(err)
+		}
+	}
+
+	res, err := bucket.KeyRange("k1", "k2").Run()
+	if err != nil {
+		c.Fatal(err)
+	}
+	if res.Len() != 2 || !res.Contains("k1") || !res.Contains("k2") {
+		c.Errorf("expected k1 and k2; got %v", res.Keys())
+	}
+
+	res, err = bucket.AllKeys().MaxResults(2).Run()
+	if err != nil {
+		c.Fatal(err)
+	}
+	if res.Len() != 2 || res.Continuation() == "" {
+		c.Errorf("expected a page of 2 keys; got %v", res.Keys())
+	}
+	s.runtime += time.Since(startt)
+}
+
+func (s *riakAsync) TestIndexStream(c *check.C) {
+	bucket := s.cl.Bucket("testbucket")
+	for i := 0; i < 5; i++ {
+		ob := &TestObject{Data: []byte("streamed")}
+		ob.Info().AddIndex("streamIdx", "streamed")
+		err := bucket.New(ob, nil)
+		if err != nil {
+			c.Fatal(err)
+		}
+	}
+
+	st, err := bucket.Query().Match("streamIdx", "streamed").Stream()
+	if err != nil {
+		c.Fatal(err)
+	}
+	n := 0
+	for {
+		_, err := st.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.Fatal(err)
+		}
+		n++
+	}
+	if n < 5 {
+		c.Errorf("expected at least 5 keys; got %d", n)
+	}
+
+	// early termination
+	st, err = bucket.Query().Match("streamIdx", "streamed").Stream()
+	if err != nil {
+		c.Fatal(err)
+	}
+	_, err = st.Next()
+	if err != nil {
+		c.Fatal(err)
+	}
+	st.Close()
+	if _, err = st.Next(); err != io.EOF {
+		c.Errorf("expected io.EOF after Close; got %v", err)
+	}
+
+	// fetch pipeline
+	st, err = bucket.Query().Match("streamIdx", "streamed").Stream()
+	if err != nil {
+		c.Fatal(err)
+	}
+	it := st.Fetch(context.Background(), &TestObject{}, &FetchOpts{Window: 3})
+	defer it.Close()
+	n = 0
+	for {
+		r, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.Fatal(err)
+		}
+		if r.Error != nil {
+			c.Fatal(r.Error)
+		}
+		if string(r.Value.(*TestObject).Data) != "streamed" {
+			c.Errorf("bad value %q", r.Value.(*TestObject).Data)
+		}
+		n++
+	}
+	if n < 5 {
+		c.Errorf("expected at least 5 objects; got %d", n)
+	}
+}
+
+func (s *riakSuite) TestIndexQueryTuple(c *check.C) {
+	startt := time.Now()
+	bucket := s.cl.Bucket("testbucket")
+	for _, score := range []int64{-10, -1, 0, 5, 100} {
+		ob := &TestObject{Data: []byte("scored")}
+		ob.Info().AddIndex("userScore", tuple.Pack("tupleuser", score))
+		err := bucket.New(ob, nil)
+		if err != nil {
+			c.Fatal(err)
+		}
+	}
+
+	res, err := bucket.Query().
+		TupleRange("userScore", tuple.Tuple{"tupleuser", int64(-5)}, tuple.Tuple{"tupleuser", int64(5)}).
+		ReturnTerms(true).Run()
+	if err != nil {
+		c.Fatal(err)
+	}
+	n := 0
+	for _, e := range res.Entries() {
+		tp, err := e.Tuple()
+		if err != nil {
+			c.Fatal(err)
+		}
+		if sc := tp[1].(int64); sc < -5 || sc > 5 {
+			c.Errorf("score %d out of range", sc)
+		}
+		n++
+	}
+	if n < 3 {
+		c.Errorf("expected at least 3 entries; got %d", n)
+	}
+	s.runtime += time.Since(startt)
+}
+
+func (s *riakSuite) TestSetQuery(c *check.C) {
+	startt := time.Now()
+	bucket := s.cl.Bucket("testsetquery")
+	for _, k := range []string{"s1", "s2", "s3", "s4"} {
+		ob := &TestObject{Data: []byte(k)}
+		if k != "s4" {
+			ob.Info().AddIndex("color", "red")
+		}
+		if k == "s2" || k == "s4" {
+			ob.Info().AddIndexInt("size", 10)
+		}
+		err := bucket.Overwrite(ob, k)
+		if err != nil {
+			c.Fatal(err)
+		}
+	}
+	red := bucket.Query().Match("color", "red")
+	big := bucket.Query().RangeInt("size", 5, 15)
+
+	res, err := And(red, big).Run()
+	if err != nil {
+		c.Fatal(err)
+	}
+	if res.Len() != 1 || !res.Contains("s2") {
+		c.Errorf("expected s2; got %v", res.Keys())
+	}
+
+	res, err = And(red, Not(big)).Run()
+	if err != nil {
+		c.Fatal(err)
+	}
+	if res.Len() != 2 || !res.Contains("s1") || !res.Contains("s3") {
+		c.Errorf("expected s1 and s3; got %v", res.Keys())
+	}
+
+	st, err := Or(red, big).Stream()
+	if err != nil {
+		c.Fatal(err)
+	}
+	var keys []string
+	for {
+		k, err := st.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.Fatal(err)
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) != 4 || keys[0] != "s1" || keys[3] != "s4" {
+		c.Errorf("expected s1 through s4; got %v", keys)
+	}
+
+	_, err = And(red, big).Buffer(1).Run()
+	if err != ErrSetBufferFull {
+		c.Errorf("expected ErrSetBufferFull; got %v", err)
+	}
+	s.runtime += time.Since(startt)
+}
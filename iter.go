@@ -0,0 +1,263 @@
+package rkive
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+var (
+	// ErrTooManyErrors is returned from (*FetchIter).Next
+	// once the number of failed fetches has reached
+	// FetchOpts.MaxErrors.
+	ErrTooManyErrors = errors.New("too many errors")
+)
+
+// FetchOpts are the options for
+// cancellable asynchronous fetches.
+type FetchOpts struct {
+	Window    int       // maximum number of fetches in flight or awaiting delivery (default 1)
+	Ordered   bool      // deliver results in the same order as the keys
+	MaxErrors int       // stop after this many failed fetches (0 = never)
+	Read      *ReadOpts // options for each fetch
+}
+
+// FetchIter is an iterator over the results of
+// asynchronous fetches. At most 'Window' objects
+// are fetched ahead of the consumer, so a slow
+// consumer slows down the fetches instead of
+// accumulating results. An iterator must be closed
+// (or its context cancelled) if it is abandoned
+// before Next returns an error; otherwise its
+// goroutines are never released.
+type FetchIter struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	window  chan struct{}
+	res     chan indexedFetch
+	wg      sync.WaitGroup
+	held    map[int]*AsyncFetch
+	next    int
+	nerr    int
+	max     int
+	ordered bool
+	srcerr  error // error from the key source
+	err     error
+}
+
+type indexedFetch struct {
+	i int
+	f *AsyncFetch
+}
+
+// MultiFetchContext fetches 'keys' asynchronously,
+// returning the results through an iterator. Fetching
+// stops when 'ctx' is cancelled. Every result has its
+// Value field type-assertable to the underlying type of 'o'.
+func (b *Bucket) MultiFetchContext(ctx context.Context, o Duplicator, opts *FetchOpts, keys ...string) *FetchIter {
+	return fetchIter(ctx, b.c, b.nm, o, opts, sliceKeys(keys), nil)
+}
+
+// FetchContext is like FetchAsync, but it returns a
+// cancellable iterator. (See: MultiFetchContext)
+func (i *IndexQueryRes) FetchContext(ctx context.Context, o Duplicator, opts *FetchOpts) *FetchIter {
+	return fetchIter(ctx, i.c, string(i.bucket), o, opts, sliceKeys(i.Keys()), nil)
+}
+
+// sliceKeys returns a key source for 'keys'
+func sliceKeys(keys []string) func() (string, error) {
+	return func() (string, error) {
+		if len(keys) == 0 {
+			return "", io.EOF
+		}
+		k := keys[0]
+		keys = keys[1:]
+		return k, nil
+	}
+}
+
+// fetchIter fetches the keys returned by 'next' until
+// it returns an error. 'stop', if non-nil, is called
+// once no more keys are needed, from the goroutine
+// that calls 'next'.
+func fetchIter(ctx context.Context, c *Client, bucket string, o Duplicator, opts *FetchOpts, next func() (string, error), stop func()) *FetchIter {
+	var fo FetchOpts
+	if opts != nil {
+		fo = *opts
+	}
+	if fo.Window <= 0 {
+		fo.Window = 1
+	}
+	f := &FetchIter{
+		window:  make(chan struct{}, fo.Window),
+		res:     make(chan indexedFetch, fo.Window),
+		max:     fo.MaxErrors,
+		ordered: fo.Ordered,
+	}
+	if fo.Ordered {
+		f.held = make(map[int]*AsyncFetch, fo.Window)
+	}
+	f.ctx, f.cancel = context.WithCancel(ctx)
+
+	type item struct {
+		i   int
+		key string
+	}
+	work := make(chan item)
+
+	// producer: the only goroutine that touches
+	// the key source, so that 'stop' never races
+	// with a read in progress. it isn't waited for,
+	// so Close doesn't block on a slow read.
+	type source struct {
+		key string
+		err error
+	}
+	keys := make(chan source)
+	go func() {
+		if stop != nil {
+			defer stop()
+		}
+		for {
+			key, err := next()
+			select {
+			case keys <- source{key, err}:
+			case <-f.ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// feeder: one key per free slot in the window
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		defer close(work)
+		for i := 0; ; i++ {
+			select {
+			case f.window <- struct{}{}:
+			case <-f.ctx.Done():
+				return
+			}
+			var src source
+			select {
+			case src = <-keys:
+			case <-f.ctx.Done():
+				return
+			}
+			if src.err != nil {
+				if src.err != io.EOF {
+					f.srcerr = src.err
+				}
+				return
+			}
+			select {
+			case work <- item{i, src.key}:
+			case <-f.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// workers never block on 'res', since
+	// it has room for the whole window
+	nw := fo.Window
+	f.wg.Add(nw)
+	for j := 0; j < nw; j++ {
+		go func() {
+			defer f.wg.Done()
+			for it := range work {
+				v := o.NewEmpty()
+				err := c.Fetch(v, bucket, it.key, fo.Read)
+				f.res <- indexedFetch{it.i, &AsyncFetch{Key: it.key, Value: v, Error: err}}
+			}
+		}()
+	}
+	go func() {
+		f.wg.Wait()
+		close(f.res)
+	}()
+	return f
+}
+
+// Next returns the next result. It returns io.EOF
+// once every result has been returned, the context's
+// error if the context is cancelled, and ErrTooManyErrors
+// once FetchOpts.MaxErrors results with errors have been
+// returned. Errors from individual fetches are returned
+// in AsyncFetch.Error, not from Next. If the keys come
+// from an IndexStream, an error reading the stream is
+// returned once the results before it have been returned.
+func (f *FetchIter) Next() (*AsyncFetch, error) {
+	for f.err == nil {
+		if f.ordered {
+			if r, ok := f.held[f.next]; ok {
+				delete(f.held, f.next)
+				f.next++
+				return f.deliver(r), nil
+			}
+		}
+		// cancellation takes priority
+		// over buffered results
+		if f.ctx.Err() != nil {
+			f.finish(f.ctx.Err())
+			break
+		}
+		select {
+		case r, ok := <-f.res:
+			if !ok {
+				// the source error is visible
+				// once 'res' has been closed
+				if f.srcerr != nil {
+					f.finish(f.srcerr)
+				} else {
+					f.finish(io.EOF)
+				}
+				break
+			}
+			if !f.ordered {
+				return f.deliver(r.f), nil
+			}
+			f.held[r.i] = r.f
+		case <-f.ctx.Done():
+			f.finish(f.ctx.Err())
+		}
+	}
+	return nil, f.err
+}
+
+// hand a result to the consumer
+func (f *FetchIter) deliver(r *AsyncFetch) *AsyncFetch {
+	<-f.window
+	if r.Error != nil {
+		f.nerr++
+		if f.max > 0 && f.nerr >= f.max {
+			f.finish(ErrTooManyErrors)
+		}
+	}
+	return r
+}
+
+// stop fetching; subsequent calls to Next return 'err'
+func (f *FetchIter) finish(err error) {
+	if f.err == nil {
+		f.err = err
+	}
+	f.cancel()
+}
+
+// Close stops the iterator and waits for
+// its goroutines to exit. Fetches that are
+// already in progress are allowed to finish.
+// If the keys come from a stream, a read that
+// is in progress finishes in the background, and
+// the stream is closed after it. Subsequent calls
+// to Next return io.EOF.
+func (f *FetchIter) Close() {
+	f.finish(io.EOF)
+	f.wg.Wait()
+}
@@ -0,0 +1,178 @@
+package rkive
+
+import (
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// a closed client fails every fetch immediately
+func closedClient() *Client { return &Client{tag: 1} }
+
+func TestFetchIterOrdered(t *testing.T) {
+	keys := make([]string, 50)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	it := fetchIter(context.Background(), closedClient(), "b", &Manifest{}, &FetchOpts{Window: 8, Ordered: true}, sliceKeys(keys), nil)
+	for i := range keys {
+		r, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Key != keys[i] {
+			t.Fatalf("expected key %q; got %q", keys[i], r.Key)
+		}
+		if r.Error == nil {
+			t.Error("expected an error from a closed client")
+		}
+	}
+	_, err := it.Next()
+	if err != io.EOF {
+		t.Errorf("expected io.EOF; got %v", err)
+	}
+}
+
+func TestFetchIterMaxErrors(t *testing.T) {
+	keys := make([]string, 50)
+	it := fetchIter(context.Background(), closedClient(), "b", &Manifest{}, &FetchOpts{Window: 4, MaxErrors: 3}, sliceKeys(keys), nil)
+	n := 0
+	for {
+		_, err := it.Next()
+		if err == ErrTooManyErrors {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if n != 3 {
+		t.Errorf("expected 3 results before stopping; got %d", n)
+	}
+	it.Close()
+}
+
+func TestFetchIterCancel(t *testing.T) {
+	before := runtime.NumGoroutine()
+	keys := make([]string, 1000)
+
+	// an abandoned iterator is
+	// released by its context
+	ctx, cancel := context.WithCancel(context.Background())
+	it := fetchIter(ctx, closedClient(), "b", &Manifest{}, &FetchOpts{Window: 4}, sliceKeys(keys), nil)
+	_, err := it.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	_, err = it.Next()
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled; got %v", err)
+	}
+
+	// ... or by Close
+	it = fetchIter(context.Background(), closedClient(), "b", &Manifest{}, &FetchOpts{Window: 4}, sliceKeys(keys), nil)
+	it.Next()
+	it.Close()
+	_, err = it.Next()
+	if err != io.EOF {
+		t.Errorf("expected io.EOF after Close; got %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines leaked", n-before)
+	}
+}
+
+func TestFetchIterSourceError(t *testing.T) {
+	bad := errors.New("stream broke")
+	n := 0
+	stopped := make(chan struct{})
+	next := func() (string, error) {
+		n++
+		if n > 3 {
+			return "", bad
+		}
+		return strconv.Itoa(n), nil
+	}
+	it := fetchIter(context.Background(), closedClient(), "b", &Manifest{}, &FetchOpts{Window: 2, Ordered: true}, next, func() { close(stopped) })
+	got := 0
+	for {
+		_, err := it.Next()
+		if err == bad {
+			break
+		}
+		if err != nil {
+			t.Fatalf("expected the source error; got %v", err)
+		}
+		got++
+	}
+	if got != 3 {
+		t.Errorf("expected 3 results before the error; got %d", got)
+	}
+	it.Close()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("source wasn't stopped")
+	}
+}
+
+func TestFetchIterCloseDuringRead(t *testing.T) {
+	reading := make(chan struct{})
+	release := make(chan struct{})
+	stopped := make(chan struct{})
+
+	// 'inRead' is deliberately unsynchronized, so
+	// that the race detector catches a call to stop
+	// that overlaps a read
+	inRead := false
+	next := func() (string, error) {
+		inRead = true
+		close(reading)
+		<-release
+		inRead = false
+		return "k", nil
+	}
+	stop := func() {
+		if inRead {
+			t.Error("stopped during a read")
+		}
+		close(stopped)
+	}
+	it := fetchIter(context.Background(), closedClient(), "b", &Manifest{}, nil, next, stop)
+	<-reading
+
+	closed := make(chan struct{})
+	go func() {
+		it.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked on a read in progress")
+	}
+	select {
+	case <-stopped:
+		t.Fatal("source was stopped during a read")
+	default:
+	}
+
+	// the source is stopped once the read finishes
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("source wasn't stopped")
+	}
+}
@@ -0,0 +1,189 @@
+package rkive
+
+import (
+	"errors"
+	"github.com/philhofer/rkive/rpbc"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultListTimeout is the server-side
+	// timeout (in ms) for list operations. The
+	// client waits as long for each response.
+	DefaultListTimeout = 60000
+)
+
+var (
+	// ErrListingDisabled is returned by ListBuckets
+	// and ListKeys unless list operations have been
+	// enabled with (*Client).AllowListing(true).
+	ErrListingDisabled = errors.New("list operations are disabled")
+
+	dfltlist uint32 = DefaultListTimeout
+)
+
+// AllowListing enables or disables list operations
+// (ListBuckets and ListKeys) on the client. Listing
+// requires a full traversal of every key in the cluster,
+// so it should never be used in production code. List
+// operations are disabled by default.
+func (c *Client) AllowListing(ok bool) {
+	if ok {
+		atomic.StoreInt32(&c.list, 1)
+	} else {
+		atomic.StoreInt32(&c.list, 0)
+	}
+}
+
+// read timeout for list streams
+func listTimeout() time.Duration {
+	return streamTimeout(time.Duration(dfltlist) * time.Millisecond)
+}
+
+func (c *Client) listing() bool { return atomic.LoadInt32(&c.list) == 1 }
+
+// BucketStream is a stream of bucket names
+// returned from ListBuckets.
+type BucketStream struct {
+	s    *streamRes
+	res  rpbc.RpbListBucketsResp
+	nms  [][]byte
+	done bool
+	err  error
+}
+
+// Next returns the next bucket name in the stream.
+// It returns io.EOF once all of the bucket names
+// have been returned.
+func (b *BucketStream) Next() (string, error) {
+	for len(b.nms) == 0 {
+		if b.done {
+			return "", b.err
+		}
+		b.res.Reset()
+		var code byte
+		b.done, code, b.err = b.s.unmarshal(&b.res)
+		if b.err == nil && code != 16 {
+			if !b.done {
+				b.s.abort()
+			}
+			b.done, b.err = true, ErrUnexpectedResponse
+		}
+		if b.err != nil {
+			return "", b.err
+		}
+		if b.done {
+			b.err = io.EOF
+		}
+		b.nms = b.res.Buckets
+	}
+	nm := string(b.nms[0])
+	b.nms = b.nms[1:]
+	return nm, nil
+}
+
+// Close stops the stream. It is safe to call
+// Close more than once, or after the stream has
+// been exhausted.
+func (b *BucketStream) Close() {
+	if !b.done {
+		b.s.abort()
+		b.done, b.err = true, io.EOF
+	}
+	b.nms = nil
+}
+
+// ListBuckets returns a stream of the names of all
+// the buckets of the given bucket type. (The empty
+// string is the default bucket type.) ListBuckets
+// returns ErrListingDisabled unless AllowListing(true)
+// has been called on the client.
+func (c *Client) ListBuckets(typ string) (*BucketStream, error) {
+	if !c.listing() {
+		return nil, ErrListingDisabled
+	}
+	req := &rpbc.RpbListBucketsReq{
+		Timeout: &dfltlist,
+		Stream:  &ptrTrue,
+	}
+	if typ != "" {
+		req.Type = []byte(typ)
+	}
+	s, err := c.streamReqTimeout(req, 15, listTimeout())
+	if err != nil {
+		return nil, err
+	}
+	return &BucketStream{s: s}, nil
+}
+
+// KeyStream is a stream of keys
+// returned from ListKeys.
+type KeyStream struct {
+	s    *streamRes
+	res  rpbc.RpbListKeysResp
+	keys [][]byte
+	done bool
+	err  error
+}
+
+// Next returns the next key in the stream.
+// It returns io.EOF once all of the keys
+// have been returned.
+func (k *KeyStream) Next() (string, error) {
+	for len(k.keys) == 0 {
+		if k.done {
+			return "", k.err
+		}
+		k.res.Reset()
+		var code byte
+		k.done, code, k.err = k.s.unmarshal(&k.res)
+		if k.err == nil && code != 18 {
+			if !k.done {
+				k.s.abort()
+			}
+			k.done, k.err = true, ErrUnexpectedResponse
+		}
+		if k.err != nil {
+			return "", k.err
+		}
+		if k.done {
+			k.err = io.EOF
+		}
+		k.keys = k.res.Keys
+	}
+	key := string(k.keys[0])
+	k.keys = k.keys[1:]
+	return key, nil
+}
+
+// Close stops the stream. It is safe to call
+// Close more than once, or after the stream has
+// been exhausted.
+func (k *KeyStream) Close() {
+	if !k.done {
+		k.s.abort()
+		k.done, k.err = true, io.EOF
+	}
+	k.keys = nil
+}
+
+// ListKeys returns a stream of every key in the bucket.
+// ListKeys returns ErrListingDisabled unless AllowListing(true)
+// has been called on the client. Listing keys is very expensive;
+// consider using a secondary index query instead.
+func (b *Bucket) ListKeys() (*KeyStream, error) {
+	if !b.c.listing() {
+		return nil, ErrListingDisabled
+	}
+	req := &rpbc.RpbListKeysReq{
+		Bucket:  []byte(b.nm),
+		Timeout: &dfltlist,
+	}
+	s, err := b.c.streamReqTimeout(req, 17, listTimeout())
+	if err != nil {
+		return nil, err
+	}
+	return &KeyStream{s: s}, nil
+}
@@ -0,0 +1,95 @@
+// +build riak
+
+package rkive
+
+import (
+	check "gopkg.in/check.v1"
+	"io"
+)
+
+func (s *riakAsync) TestListKeys(c *check.C) {
+	bucket := s.cl.Bucket("testlist")
+
+	_, err := bucket.ListKeys()
+	if err != ErrListingDisabled {
+		c.Fatalf("expected ErrListingDisabled; got %v", err)
+	}
+
+	ob := &TestObject{Data: []byte("list me")}
+	err = bucket.New(ob, nil)
+	if err != nil {
+		c.Fatal(err)
+	}
+
+	s.cl.AllowListing(true)
+	defer s.cl.AllowListing(false)
+
+	ks, err := bucket.ListKeys()
+	if err != nil {
+		c.Fatal(err)
+	}
+	found := false
+	for {
+		key, err := ks.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.Fatal(err)
+		}
+		if key == ob.Info().Key() {
+			found = true
+		}
+	}
+	if !found {
+		c.Errorf("key %q not in list", ob.Info().Key())
+	}
+
+	// early termination shouldn't
+	// leave a bad connection in the pool
+	ks, err = bucket.ListKeys()
+	if err != nil {
+		c.Fatal(err)
+	}
+	ks.Close()
+	if err = s.cl.Ping(); err != nil {
+		c.Fatal(err)
+	}
+}
+
+func (s *riakAsync) TestListBuckets(c *check.C) {
+	_, err := s.cl.ListBuckets("")
+	if err != ErrListingDisabled {
+		c.Fatalf("expected ErrListingDisabled; got %v", err)
+	}
+
+	ob := &TestObject{Data: []byte("list me")}
+	err = s.cl.New(ob, "testlist", nil, nil)
+	if err != nil {
+		c.Fatal(err)
+	}
+
+	s.cl.AllowListing(true)
+	defer s.cl.AllowListing(false)
+
+	bs, err := s.cl.ListBuckets("")
+	if err != nil {
+		c.Fatal(err)
+	}
+	found := false
+	for {
+		nm, err := bs.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.Fatal(err)
+		}
+		if nm == "testlist" {
+			found = true
+		}
+	}
+	if !found {
+		c.Error("bucket \"testlist\" not in list")
+	}
+}
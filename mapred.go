@@ -0,0 +1,346 @@
+package rkive
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/philhofer/rkive/rpbc"
+	"io"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrNoInputs is returned when a MapReduce
+	// job is run before its inputs have been set.
+	ErrNoInputs = errors.New("mapreduce job has no inputs")
+
+	// ErrNoPhases is returned when a MapReduce
+	// job is run without any phases.
+	ErrNoPhases = errors.New("mapreduce job has no phases")
+
+	ctypeJSON = []byte("application/json")
+)
+
+// MRFunc is a function used in a map
+// or reduce phase. MRFuncs are created with
+// JSSource, JSNamed and ErlangFunc.
+type MRFunc struct {
+	lang   string
+	source string
+	name   string
+	module string
+	fn     string
+}
+
+// JSSource is an anonymous Javascript function,
+// e.g. "function(v) { return [v.key]; }"
+func JSSource(src string) MRFunc { return MRFunc{lang: "javascript", source: src} }
+
+// JSNamed is a Javascript function that has been
+// loaded by the Riak nodes, e.g. "Riak.mapValuesJson"
+func JSNamed(name string) MRFunc { return MRFunc{lang: "javascript", name: name} }
+
+// ErlangFunc is a function exported from
+// an Erlang module on the Riak nodes, e.g.
+// ErlangFunc("riak_kv_mapreduce", "map_object_value")
+func ErlangFunc(module string, function string) MRFunc {
+	return MRFunc{lang: "erlang", module: module, fn: function}
+}
+
+// phase spec, as it appears in the job
+type mrSpec struct {
+	Language string      `json:"language,omitempty"`
+	Source   string      `json:"source,omitempty"`
+	Name     string      `json:"name,omitempty"`
+	Module   string      `json:"module,omitempty"`
+	Function string      `json:"function,omitempty"`
+	Bucket   string      `json:"bucket,omitempty"`
+	Tag      string      `json:"tag,omitempty"`
+	Keep     bool        `json:"keep"`
+	Arg      interface{} `json:"arg,omitempty"`
+}
+
+type mrJob struct {
+	Inputs  interface{}         `json:"inputs"`
+	Query   []map[string]mrSpec `json:"query"`
+	Timeout uint32              `json:"timeout,omitempty"`
+}
+
+// MapReduce is a MapReduce job. Jobs are built
+// with (*Client).MapReduce, followed by one kind
+// of input (Bucket, Keys and KeyData, or Index) and
+// one or more phases, and executed with Run or Stream:
+//
+//   res, err := cl.MapReduce().Bucket("users").
+//       Map(JSNamed("Riak.mapValuesJson"), nil, false).
+//       Reduce(ErlangFunc("riak_kv_mapreduce", "reduce_count_inputs"), nil, true).
+//       Run()
+//
+// Jobs are sent as JSON, so phase arguments
+// and key data must be encodable with encoding/json.
+// Phases with 'keep' set return their results;
+// Riak always returns the results of the last phase.
+type MapReduce struct {
+	c       *Client
+	bucket  string        // whole-bucket input
+	triples []interface{} // bucket/key(/keydata) inputs
+	index   map[string]interface{}
+	phases  []map[string]mrSpec
+	timeout time.Duration
+	err     error
+}
+
+// MapReduce begins a MapReduce job.
+func (c *Client) MapReduce() *MapReduce { return &MapReduce{c: c} }
+
+// MapReduce begins a MapReduce job with
+// every key in the bucket as its input.
+func (b *Bucket) MapReduce() *MapReduce { return b.c.MapReduce().Bucket(b.nm) }
+
+func (m *MapReduce) clearInputs() {
+	m.bucket, m.triples, m.index, m.err = "", nil, nil, nil
+}
+
+// Bucket uses every key in 'bucket' as the input
+// to the job. Like listing keys, this is expensive.
+func (m *MapReduce) Bucket(bucket string) *MapReduce {
+	m.clearInputs()
+	m.bucket = bucket
+	return m
+}
+
+// Keys adds bucket/key pairs to the inputs of the job.
+func (m *MapReduce) Keys(bucket string, keys ...string) *MapReduce {
+	if m.bucket != "" || m.index != nil || m.err != nil {
+		m.clearInputs()
+	}
+	for _, k := range keys {
+		m.triples = append(m.triples, [2]string{bucket, k})
+	}
+	return m
+}
+
+// KeyData adds a bucket/key pair to the inputs of
+// the job along with data that is passed to the first
+// phase as its 'keydata' argument.
+func (m *MapReduce) KeyData(bucket string, key string, data interface{}) *MapReduce {
+	if m.bucket != "" || m.index != nil || m.err != nil {
+		m.clearInputs()
+	}
+	m.triples = append(m.triples, [3]interface{}{bucket, key, data})
+	return m
+}
+
+// Index uses the results of a secondary index query
+// as the input to the job. The query must use Match,
+// MatchInt, Range, RangeInt or KeyRange; other options
+// on the query are ignored.
+func (m *MapReduce) Index(q *IndexQuery) *MapReduce {
+	m.clearInputs()
+	if !q.set {
+		m.err = ErrNoCondition
+		return m
+	}
+	if q.req.TermRegex != nil {
+		m.err = ErrBadRegexQuery
+		return m
+	}
+	in := map[string]interface{}{
+		"bucket": string(q.req.Bucket),
+		"index":  string(q.req.Index),
+	}
+	term := func(b []byte) interface{} {
+		// riak expects integer terms for "_int" indexes
+		if bytes.HasSuffix(q.req.Index, []byte("_int")) {
+			i, err := strconv.ParseInt(string(b), 10, 64)
+			if err != nil {
+				m.err = err
+			}
+			return i
+		}
+		return string(b)
+	}
+	if q.req.GetQtype() == rpbc.RpbIndexReq_eq {
+		in["key"] = term(q.req.Key)
+	} else {
+		in["start"] = term(q.req.RangeMin)
+		in["end"] = term(q.req.RangeMax)
+	}
+	if m.err == nil {
+		m.index = in
+	}
+	return m
+}
+
+func (m *MapReduce) phase(kind string, f MRFunc, arg interface{}, keep bool) *MapReduce {
+	m.phases = append(m.phases, map[string]mrSpec{kind: {
+		Language: f.lang,
+		Source:   f.source,
+		Name:     f.name,
+		Module:   f.module,
+		Function: f.fn,
+		Keep:     keep,
+		Arg:      arg,
+	}})
+	return m
+}
+
+// Map adds a map phase to the job. 'arg', if
+// non-nil, is passed to every call of 'f'. If 'keep'
+// is set, the results of the phase are returned.
+func (m *MapReduce) Map(f MRFunc, arg interface{}, keep bool) *MapReduce {
+	return m.phase("map", f, arg, keep)
+}
+
+// Reduce adds a reduce phase to the job. (See: Map)
+func (m *MapReduce) Reduce(f MRFunc, arg interface{}, keep bool) *MapReduce {
+	return m.phase("reduce", f, arg, keep)
+}
+
+// Link adds a link phase to the job, which follows
+// the links with tag 'tag' to objects in 'bucket'.
+// An empty bucket or tag matches any bucket or tag.
+func (m *MapReduce) Link(bucket string, tag string, keep bool) *MapReduce {
+	if bucket == "" {
+		bucket = "_"
+	}
+	if tag == "" {
+		tag = "_"
+	}
+	m.phases = append(m.phases, map[string]mrSpec{"link": {Bucket: bucket, Tag: tag, Keep: keep}})
+	return m
+}
+
+// Timeout sets the timeout of the whole job.
+// (The default is Riak's, which is one minute.)
+func (m *MapReduce) Timeout(d time.Duration) *MapReduce {
+	m.timeout = d
+	return m
+}
+
+// Job returns the JSON encoding
+// of the job, as it is sent to Riak.
+func (m *MapReduce) Job() ([]byte, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	job := mrJob{
+		Query:   m.phases,
+		Timeout: uint32(m.timeout / time.Millisecond),
+	}
+	switch {
+	case m.bucket != "":
+		job.Inputs = m.bucket
+	case m.index != nil:
+		job.Inputs = m.index
+	case len(m.triples) > 0:
+		job.Inputs = m.triples
+	default:
+		return nil, ErrNoInputs
+	}
+	if len(m.phases) == 0 {
+		return nil, ErrNoPhases
+	}
+	return json.Marshal(&job)
+}
+
+// Stream executes the job, returning the
+// results as they arrive. (See: MapRedStream)
+func (m *MapReduce) Stream() (*MapRedStream, error) {
+	job, err := m.Job()
+	if err != nil {
+		return nil, err
+	}
+	req := &rpbc.RpbMapRedReq{
+		Request:     job,
+		ContentType: ctypeJSON,
+	}
+	s, err := m.c.streamReqTimeout(req, 23, streamTimeout(m.timeout))
+	if err != nil {
+		return nil, err
+	}
+	return &MapRedStream{s: s}, nil
+}
+
+// Run executes the job, returning the results of
+// each phase that returns results, indexed by phase
+// number. (The first phase is phase 0.)
+func (m *MapReduce) Run() (map[int][]json.RawMessage, error) {
+	s, err := m.Stream()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[int][]json.RawMessage)
+	for {
+		phase, v, err := s.Next()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return out, err
+		}
+		out[phase] = append(out[phase], v)
+	}
+}
+
+// MapRedStream is a streaming MapReduce result.
+// A MapRedStream holds a connection until it has
+// been read to the end or closed.
+type MapRedStream struct {
+	s     *streamRes
+	res   rpbc.RpbMapRedResp
+	phase int
+	vals  []json.RawMessage
+	done  bool
+	err   error
+}
+
+// Next returns the next result and the number of
+// the phase that produced it. Next returns io.EOF
+// once every result has been returned. An error
+// in the job is returned as a RiakError.
+func (m *MapRedStream) Next() (int, json.RawMessage, error) {
+	for len(m.vals) == 0 {
+		if m.done {
+			return 0, nil, m.err
+		}
+		m.res.Reset()
+		var code byte
+		m.done, code, m.err = m.s.unmarshal(&m.res)
+		if m.err == nil && code != 24 {
+			m.err = ErrUnexpectedResponse
+		}
+		// each response is a JSON array
+		// of the results from one phase
+		if m.err == nil && len(m.res.Response) > 0 {
+			m.phase = int(m.res.GetPhase())
+			m.err = json.Unmarshal(m.res.Response, &m.vals)
+		}
+		if m.err != nil {
+			if !m.done {
+				m.s.abort()
+			}
+			m.done = true
+			m.vals = nil
+			return 0, nil, m.err
+		}
+		if m.done {
+			m.err = io.EOF
+		}
+	}
+	v := m.vals[0]
+	m.vals = m.vals[1:]
+	return m.phase, v, nil
+}
+
+// Close stops the stream. It is safe to call
+// Close more than once, and after the stream
+// has been read to the end.
+func (m *MapRedStream) Close() {
+	if !m.done {
+		m.s.abort()
+		m.done, m.err = true, io.EOF
+	}
+	m.vals = nil
+}
@@ -0,0 +1,46 @@
+// +build riak
+
+package rkive
+
+import (
+	"encoding/json"
+	check "gopkg.in/check.v1"
+	"time"
+)
+
+func (s *riakSuite) TestMapReduce(c *check.C) {
+	startt := time.Now()
+	bucket := s.cl.Bucket("testmapred")
+	for _, k := range []string{"m1", "m2", "m3"} {
+		ob := &TestObject{Data: []byte(k)}
+		ob.Info().AddIndex("group", "a")
+		err := bucket.Overwrite(ob, k)
+		if err != nil {
+			c.Fatal(err)
+		}
+	}
+	count := ErlangFunc("riak_kv_mapreduce", "reduce_count_inputs")
+
+	res, err := s.cl.MapReduce().Keys("testmapred", "m1", "m2").
+		Reduce(count, nil, true).
+		Timeout(10 * time.Second).
+		Run()
+	if err != nil {
+		c.Fatal(err)
+	}
+	var n int
+	if len(res[0]) != 1 || json.Unmarshal(res[0][0], &n) != nil || n != 2 {
+		c.Errorf("expected a count of 2; got %v", res)
+	}
+
+	res, err = s.cl.MapReduce().Index(bucket.Query().Match("group", "a")).
+		Reduce(count, nil, true).
+		Run()
+	if err != nil {
+		c.Fatal(err)
+	}
+	if len(res[0]) != 1 || json.Unmarshal(res[0][0], &n) != nil || n != 3 {
+		c.Errorf("expected a count of 3; got %v", res)
+	}
+	s.runtime += time.Since(startt)
+}
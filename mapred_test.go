@@ -0,0 +1,115 @@
+package rkive
+
+import (
+	"encoding/json"
+	"github.com/philhofer/rkive/rpbc"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMapReduceJob(t *testing.T) {
+	c := &Client{}
+	m := c.MapReduce()
+	if _, err := m.Job(); err != ErrNoInputs {
+		t.Errorf("expected ErrNoInputs; got %v", err)
+	}
+	m.Keys("b", "k1", "k2").KeyData("b", "k3", 3)
+	if _, err := m.Job(); err != ErrNoPhases {
+		t.Errorf("expected ErrNoPhases; got %v", err)
+	}
+
+	m.Map(JSSource("function(v) { return [1]; }"), nil, false).
+		Link("", "friend", false).
+		Reduce(ErlangFunc("riak_kv_mapreduce", "reduce_sum"), []int{1}, true).
+		Timeout(5 * time.Second)
+	job, err := m.Job()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got interface{}
+	if err = json.Unmarshal(job, &got); err != nil {
+		t.Fatal(err)
+	}
+	var want interface{}
+	json.Unmarshal([]byte(`{
+		"inputs": [["b", "k1"], ["b", "k2"], ["b", "k3", 3]],
+		"query": [
+			{"map": {"language": "javascript", "source": "function(v) { return [1]; }", "keep": false}},
+			{"link": {"bucket": "_", "tag": "friend", "keep": false}},
+			{"reduce": {"language": "erlang", "module": "riak_kv_mapreduce", "function": "reduce_sum", "keep": true, "arg": [1]}}
+		],
+		"timeout": 5000
+	}`), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got job %s", job)
+	}
+}
+
+func TestMapReduceInputs(t *testing.T) {
+	c := &Client{}
+	tests := []struct {
+		m    *MapReduce
+		want string
+	}{
+		{c.Bucket("b").MapReduce(), `"b"`},
+		{c.MapReduce().Index(c.Query("b").Match("name", "joe")), `{"bucket": "b", "index": "name_bin", "key": "joe"}`},
+		{c.MapReduce().Index(c.Query("b").RangeInt("age", 18, 65)), `{"bucket": "b", "index": "age_int", "start": 18, "end": 65}`},
+		{c.MapReduce().Index(c.Query("b").MatchInt("age", 3)).Keys("c", "k"), `[["c", "k"]]`},
+	}
+	for i, tt := range tests {
+		job, err := tt.m.Map(JSNamed("Riak.mapValuesJson"), nil, true).Job()
+		if err != nil {
+			t.Fatalf("test %d: %s", i, err)
+		}
+		var got struct{ Inputs interface{} }
+		var want interface{}
+		json.Unmarshal(job, &got)
+		json.Unmarshal([]byte(tt.want), &want)
+		if !reflect.DeepEqual(got.Inputs, want) {
+			t.Errorf("test %d: got job %s", i, job)
+		}
+	}
+
+	m := c.MapReduce().Index(c.Query("b").Range("x", "a", "z").TermRegex("^a"))
+	if _, err := m.Map(JSNamed("Riak.mapValuesJson"), nil, true).Job(); err != ErrBadRegexQuery {
+		t.Errorf("expected ErrBadRegexQuery; got %v", err)
+	}
+
+	q := c.Query("b").MatchInt("age", 3)
+	q.req.Key = []byte("three")
+	if _, err := c.MapReduce().Index(q).Map(JSNamed("Riak.mapValuesJson"), nil, true).Job(); err == nil {
+		t.Error("expected an error for a non-integer term")
+	}
+}
+
+func TestMapReduceResetsTimeout(t *testing.T) {
+	var phase uint32
+	c, accepted, stop := fakeRiak(t, map[byte]protom{
+		23: &rpbc.RpbMapRedResp{Phase: &phase, Response: []byte("[1]"), Done: &ptrTrue},
+	})
+	defer stop()
+	res, err := c.MapReduce().Keys("b", "k").
+		Reduce(ErlangFunc("riak_kv_mapreduce", "reduce_count_inputs"), nil, true).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res[0]) != 1 {
+		t.Errorf("expected one result; got %v", res)
+	}
+	cn := pooledConn(t, c)
+	if cn.rto != 0 {
+		t.Errorf("pooled connection has read timeout %s", cn.rto)
+	}
+	c.pool.Put(cn)
+
+	// the next request should use the same connection
+	if err := c.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(accepted); n != 1 {
+		t.Errorf("expected 1 connection; got %d", n)
+	}
+}
@@ -0,0 +1,123 @@
+package rkive
+
+import (
+	"bytes"
+	"github.com/philhofer/rkive/rpbc"
+)
+
+// MetaPolicy determines how the metadata (secondary
+// indexes, links, and user metadata) of siblings is
+// combined when they are merged.
+type MetaPolicy int
+
+const (
+	// MetaUnion keeps every index entry, link, and
+	// metadata key found on any sibling. For metadata
+	// keys with conflicting values, the value already on
+	// the merge receiver is kept. This is the default.
+	MetaUnion MetaPolicy = iota + 1
+
+	// MetaIntersect keeps only the index entries, links,
+	// and metadata key-value pairs found on every sibling.
+	MetaIntersect
+
+	// MetaFirst keeps the metadata of the first
+	// sibling and discards the rest.
+	MetaFirst
+)
+
+// SetMetaPolicy sets the default metadata merge
+// policy for every bucket.
+func (c *Client) SetMetaPolicy(p MetaPolicy) {
+	c.setConf(nil, func(bc *bucketConf) { bc.meta = p })
+}
+
+// SetMetaPolicy sets the metadata merge policy for
+// the bucket, which overrides the client's policy.
+func (b *Bucket) SetMetaPolicy(p MetaPolicy) {
+	b.c.setConf(&b.nm, func(bc *bucketConf) { bc.meta = p })
+}
+
+// MergeInfo combines the indexes, links, and user
+// metadata of 'from' into 'into' according to the
+// policy 'p'. It is called automatically after ObjectM.Merge
+// during sibling merges, and it can be used by Resolvers
+// that build a merged value.
+func MergeInfo(into *Info, from *Info, p MetaPolicy) {
+	switch p {
+	case MetaFirst:
+		return
+	case MetaIntersect:
+		into.idxs = intersectPairs(into.idxs, from.idxs, true)
+		into.meta = intersectPairs(into.meta, from.meta, true)
+		into.links = intersectLinks(into.links, from.links)
+	default:
+		into.idxs = unionPairs(into.idxs, from.idxs, true)
+		into.meta = unionPairs(into.meta, from.meta, false)
+		into.links = unionLinks(into.links, from.links)
+	}
+}
+
+// find pair in 'l'; if 'byval', both key
+// and value must match
+func hasPair(l []*rpbc.RpbPair, p *rpbc.RpbPair, byval bool) bool {
+	for _, item := range l {
+		if bytes.Equal(item.Key, p.Key) && (!byval || bytes.Equal(item.Value, p.Value)) {
+			return true
+		}
+	}
+	return false
+}
+
+func unionPairs(a []*rpbc.RpbPair, b []*rpbc.RpbPair, byval bool) []*rpbc.RpbPair {
+	for _, p := range b {
+		if !hasPair(a, p, byval) {
+			a = append(a, &rpbc.RpbPair{Key: p.Key, Value: p.Value})
+		}
+	}
+	return a
+}
+
+func intersectPairs(a []*rpbc.RpbPair, b []*rpbc.RpbPair, byval bool) []*rpbc.RpbPair {
+	out := a[0:0]
+	for _, p := range a {
+		if hasPair(b, p, byval) {
+			out = append(out, p)
+		}
+	}
+	for i := len(out); i < len(a); i++ {
+		a[i] = nil
+	}
+	return out
+}
+
+func hasLink(l []*rpbc.RpbLink, k *rpbc.RpbLink) bool {
+	for _, item := range l {
+		if bytes.Equal(item.Tag, k.Tag) && bytes.Equal(item.Bucket, k.Bucket) && bytes.Equal(item.Key, k.Key) {
+			return true
+		}
+	}
+	return false
+}
+
+func unionLinks(a []*rpbc.RpbLink, b []*rpbc.RpbLink) []*rpbc.RpbLink {
+	for _, k := range b {
+		if !hasLink(a, k) {
+			a = append(a, &rpbc.RpbLink{Bucket: k.Bucket, Key: k.Key, Tag: k.Tag})
+		}
+	}
+	return a
+}
+
+func intersectLinks(a []*rpbc.RpbLink, b []*rpbc.RpbLink) []*rpbc.RpbLink {
+	out := a[0:0]
+	for _, k := range a {
+		if hasLink(b, k) {
+			out = append(out, k)
+		}
+	}
+	for i := len(out); i < len(a); i++ {
+		a[i] = nil
+	}
+	return out
+}
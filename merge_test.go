@@ -0,0 +1,69 @@
+package rkive
+
+import (
+	"github.com/philhofer/rkive/rpbc"
+	"testing"
+)
+
+// concatenating ObjectM
+type catBlob struct {
+	Blob
+}
+
+func (c *catBlob) NewEmpty() Object { return &catBlob{} }
+
+func (c *catBlob) Merge(o Object) { c.Content = append(c.Content, o.(*catBlob).Content...) }
+
+func TestMergeInfo(t *testing.T) {
+	a, b := &Info{}, &Info{}
+	a.AddIndex("tag", "x")
+	a.AddIndex("owner", "joe")
+	a.SetMeta("color", "red")
+	a.AddLink("parent", "people", "joe")
+	b.AddIndex("tag", "y")
+	b.AddIndex("owner", "joe")
+	b.SetMeta("color", "blue")
+	b.SetMeta("size", "large")
+
+	u := &Info{}
+	copyInfo(u, a)
+	MergeInfo(u, b, MetaUnion)
+	if len(u.Indexes()) != 3 {
+		t.Errorf("union indexes: %v", u.Indexes())
+	}
+	if u.GetMeta("color") != "red" || u.GetMeta("size") != "large" {
+		t.Errorf("union meta: %v", u.Metas())
+	}
+	if bk, _ := u.GetLink("parent"); bk != "people" {
+		t.Error("union lost link")
+	}
+
+	MergeInfo(a, b, MetaIntersect)
+	if idx := a.Indexes(); len(idx) != 1 || idx[0] != [2]string{"owner_bin", "joe"} {
+		t.Errorf("intersect indexes: %v", idx)
+	}
+	if len(a.Metas()) != 0 || len(a.links) != 0 {
+		t.Errorf("intersect meta: %v; links: %v", a.Metas(), a.links)
+	}
+}
+
+func TestHandleMergeIndexes(t *testing.T) {
+	ct := []*rpbc.RpbContent{
+		{Value: []byte("a"), Indexes: []*rpbc.RpbPair{{Key: []byte("tag_bin"), Value: []byte("a")}}},
+		{Value: []byte("b"), Indexes: []*rpbc.RpbPair{{Key: []byte("tag_bin"), Value: []byte("b")}}},
+	}
+	ob := &catBlob{}
+	err := handleMerge(ob, ct, &bucketConf{meta: MetaUnion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ob.Content) != "ab" {
+		t.Errorf("Content: %q", ob.Content)
+	}
+	if len(ob.Info().Indexes()) != 2 {
+		t.Errorf("Expected both index entries; got %v", ob.Info().Indexes())
+	}
+	if vals := ob.Info().IndexValues("tag"); len(vals) != 2 {
+		t.Errorf("Expected both tag values; got %v", vals)
+	}
+}
@@ -0,0 +1,286 @@
+package rkive
+
+import (
+	"errors"
+	"fmt"
+	"github.com/philhofer/rkive/rpbc"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MetaVersion is the usermeta key under which
+	// the schema version of an object's value is
+	// stored. Values without a version are treated
+	// as version 0.
+	MetaVersion = "rkive-version"
+)
+
+// Upgrade converts the marshaled form of an
+// object from one schema version to the next.
+// Upgrades operate on the output of Marshal,
+// before Unmarshal is called.
+type Upgrade func(v []byte) ([]byte, error)
+
+// ErrNoUpgrade is the type of error returned
+// when a value is stored with a schema version
+// that cannot be upgraded to the bucket's
+// current version.
+type ErrNoUpgrade struct {
+	Bucket  string
+	Key     string
+	Version int
+}
+
+func (e *ErrNoUpgrade) Error() string {
+	return fmt.Sprintf("no upgrade from schema version %d for %s/%s", e.Version, e.Bucket, e.Key)
+}
+
+// schema is a bucket's upgrade registry.
+// schemas are never modified once they
+// are visible to readers.
+type schema struct {
+	ups map[int]Upgrade
+	cur int  // current version
+	wb  bool // write back upgraded values
+}
+
+// RegisterUpgrade registers an upgrade from schema
+// version 'from' to version 'from+1' for objects in
+// the bucket. The bucket's current schema version is
+// one more than the highest version registered. Every
+// value written to the bucket is marked with the current
+// version, and every value read from the bucket is upgraded
+// to the current version before it is unmarshaled.
+func (b *Bucket) RegisterUpgrade(from int, fn Upgrade) {
+	b.c.setConf(&b.nm, func(bc *bucketConf) {
+		s := &schema{ups: make(map[int]Upgrade)}
+		if bc.schema != nil {
+			*s = *bc.schema
+			s.ups = make(map[int]Upgrade, len(bc.schema.ups)+1)
+			for v, up := range bc.schema.ups {
+				s.ups[v] = up
+			}
+		}
+		s.ups[from] = fn
+		if from+1 > s.cur {
+			s.cur = from + 1
+		}
+		bc.schema, bc.schm = s, 1
+	})
+}
+
+// ClearUpgrades removes every upgrade registered
+// for the bucket. Values written afterwards are not
+// marked with a schema version, and values read are
+// not upgraded.
+func (b *Bucket) ClearUpgrades() {
+	b.c.setConf(&b.nm, func(bc *bucketConf) { bc.schema, bc.schm = nil, -1 })
+}
+
+// SetWriteBack determines whether or not objects that
+// are upgraded when they are fetched are written back
+// to the database by Fetch, Update and Fold, including
+// objects with siblings that are resolved or merged.
+// (Objects returned by FetchSiblings are not written back,
+// nor are objects fetched by Store and Push in order to resolve
+// siblings, since those are written at the current version anyway.)
+// Write-backs are conditional (see Push), so they never
+// overwrite a concurrent change, and they are best-effort:
+// a failed write-back is logged, and never causes the read
+// to fail. Write-backs are off by default. SetWriteBack has
+// no effect until an upgrade has been registered.
+func (b *Bucket) SetWriteBack(on bool) {
+	b.c.setConf(&b.nm, func(bc *bucketConf) {
+		if bc.schema != nil {
+			s := *bc.schema
+			s.wb = on
+			bc.schema = &s
+		}
+	})
+}
+
+// SchemaVersion returns the schema version
+// with which the object was stored, or 0
+// if it has no version.
+func (in *Info) SchemaVersion() int {
+	return version(in.meta)
+}
+
+func version(meta []*rpbc.RpbPair) int {
+	v := get(&meta, ustr(MetaVersion))
+	if v == nil {
+		return 0
+	}
+	n, err := strconv.Atoi(string(v))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// append the current version to 'meta'
+func addVersion(s *schema, meta []*rpbc.RpbPair) []*rpbc.RpbPair {
+	return append(meta, &rpbc.RpbPair{
+		Key:   []byte(MetaVersion),
+		Value: strconv.AppendInt(nil, int64(s.cur), 10),
+	})
+}
+
+// upgrade 'v' to the current version
+func upgrade(o Object, v []byte, meta []*rpbc.RpbPair, s *schema) ([]byte, error) {
+	if s == nil {
+		return v, nil
+	}
+	from := version(meta)
+	if from > s.cur {
+		return nil, &ErrNoUpgrade{Bucket: o.Info().Bucket(), Key: o.Info().Key(), Version: from}
+	}
+	var err error
+	for n := from; n < s.cur; n++ {
+		up, ok := s.ups[n]
+		if !ok {
+			return nil, &ErrNoUpgrade{Bucket: o.Info().Bucket(), Key: o.Info().Key(), Version: from}
+		}
+		v, err = up(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// stale returns whether or not 'o' was
+// read from a value older than the current version
+func stale(o Object, s *schema) bool {
+	return s != nil && o.Info().SchemaVersion() < s.cur
+}
+
+// writeBack persists a freshly-upgraded object.
+// It is best-effort: the object has already been
+// read successfully, so a failed write is logged
+// rather than returned. (A concurrent modification
+// means the value has already been re-written, so
+// it isn't logged.) Objects that fail to write back
+// are upgraded again on the next read.
+func (c *Client) writeBack(o Object, cf *bucketConf) {
+	if cf.schema == nil || !cf.schema.wb || !stale(o, cf.schema) {
+		return
+	}
+	err := c.Push(o, nil)
+	if err != nil && err != ErrModified {
+		logger.Printf("write-back of %s/%s failed: %s", o.Info().Bucket(), o.Info().Key(), err)
+	}
+}
+
+// MigrationProgress describes the progress
+// of a bulk migration.
+type MigrationProgress struct {
+	Scanned  int    // number of keys examined
+	Migrated int    // number of objects re-written
+	Failed   int    // number of objects that could not be migrated
+	Key      string // the last key examined
+	Err      error  // the error for 'Key', if any
+}
+
+// Migrate re-writes every object in the bucket that is
+// stored with an old schema version. Keys are found with
+// a streaming $bucket index scan (see AllKeys), so the
+// bucket must use a backend that supports secondary indexes.
+// Stream chunks (see ChunkPrefix) are skipped.
+// 'o' is used to create the objects that are fetched. If 'fn'
+// is non-nil, it is called after each key is examined. Failures
+// to migrate individual objects are reported through 'fn' and
+// counted, but they do not stop the migration.
+func (b *Bucket) Migrate(o Duplicator, fn func(MigrationProgress)) (MigrationProgress, error) {
+	return b.migrateQuery(o, b.AllKeys(), fn)
+}
+
+// MigrateIndex is like Migrate, but it only examines
+// the objects with the given index-value pair.
+func (b *Bucket) MigrateIndex(o Duplicator, index string, value string, fn func(MigrationProgress)) (MigrationProgress, error) {
+	return b.migrateQuery(o, b.Query().Match(index, value), fn)
+}
+
+// migrate the keys streamed by 'q'
+func (b *Bucket) migrateQuery(o Duplicator, q *IndexQuery, fn func(MigrationProgress)) (MigrationProgress, error) {
+	ks, err := q.Stream()
+	if err != nil {
+		return MigrationProgress{}, err
+	}
+	defer ks.Close()
+	return b.migrate(o, ks.Next, fn)
+}
+
+func (b *Bucket) migrate(o Duplicator, next func() (string, error), fn func(MigrationProgress)) (MigrationProgress, error) {
+	var p MigrationProgress
+	for {
+		key, err := next()
+		if err == io.EOF {
+			return p, nil
+		}
+		if err != nil {
+			return p, err
+		}
+		// stream chunks aren't values
+		if strings.HasPrefix(key, ChunkPrefix) {
+			continue
+		}
+		p.Scanned++
+		p.Key = key
+		var ok bool
+		ok, p.Err = b.migrateKey(o, key)
+		if p.Err != nil {
+			p.Failed++
+		} else if ok {
+			p.Migrated++
+		}
+		if fn != nil {
+			fn(p)
+		}
+	}
+}
+
+// migrate a single key; returns whether
+// or not the object was re-written
+func (b *Bucket) migrateKey(o Duplicator, key string) (bool, error) {
+	cf := b.c.conf(ustr(b.nm))
+	if cf.schema == nil {
+		return false, nil
+	}
+	// the head is usually enough to check the
+	// version; siblings need a full fetch
+	hd, err := b.c.FetchHead(b.nm, key)
+	if err == ErrNotFound || errors.Is(err, ErrDeleted) {
+		return false, nil
+	}
+	if _, ok := err.(*ErrMultipleResponses); !ok {
+		if err != nil {
+			return false, err
+		}
+		if hd.SchemaVersion() >= cf.schema.cur {
+			return false, nil
+		}
+	}
+	ob := o.NewEmpty()
+	for i := 0; i <= maxMerges; i++ {
+		err = b.c.Fetch(ob, b.nm, key, nil)
+		if err == ErrNotFound || errors.Is(err, ErrDeleted) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		// write-back or a concurrent writer
+		// may have already done the work
+		if !stale(ob, cf.schema) {
+			return true, nil
+		}
+		err = b.c.Push(ob, nil)
+		if err != ErrModified {
+			return err == nil, err
+		}
+	}
+	return false, ErrModified
+}
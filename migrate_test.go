@@ -0,0 +1,105 @@
+package rkive
+
+import (
+	"bytes"
+	"github.com/philhofer/rkive/rpbc"
+	"testing"
+)
+
+func TestUpgrade(t *testing.T) {
+	// v0 -> v1 -> v2
+	s := &schema{
+		ups: map[int]Upgrade{
+			0: func(v []byte) ([]byte, error) { return append(v, " v1"...), nil },
+			1: func(v []byte) ([]byte, error) { return bytes.ToUpper(v), nil },
+		},
+		cur: 2,
+	}
+
+	// unversioned value
+	old := &rpbc.RpbContent{}
+	err := writeContent(&Blob{Content: []byte("old")}, old, nil, nil, &bucketConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if get(&old.Usermeta, ustr(MetaVersion)) != nil {
+		t.Fatal("version written without a schema")
+	}
+
+	out := &Blob{}
+	err = readContent(out, old, &bucketConf{schema: s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out.Content) != "OLD V1" {
+		t.Errorf("expected %q; got %q", "OLD V1", out.Content)
+	}
+	if out.Info().SchemaVersion() != 0 || !stale(out, s) {
+		t.Errorf("object should be stale at version 0; got version %d", out.Info().SchemaVersion())
+	}
+
+	// current values are untouched
+	cur := &rpbc.RpbContent{}
+	err = writeContent(&Blob{Content: []byte("new")}, cur, nil, nil, &bucketConf{schema: s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = readContent(out, cur, &bucketConf{schema: s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out.Content) != "new" {
+		t.Errorf("expected %q; got %q", "new", out.Content)
+	}
+	if out.Info().SchemaVersion() != 2 || stale(out, s) {
+		t.Errorf("expected version 2; got %d", out.Info().SchemaVersion())
+	}
+
+	// values from the future can't be read
+	out.Info().bucket, out.Info().key = []byte("b"), []byte("k")
+	err = readContent(out, cur, &bucketConf{schema: &schema{cur: 1}})
+	nu, ok := err.(*ErrNoUpgrade)
+	if !ok {
+		t.Fatalf("expected *ErrNoUpgrade; got %v", err)
+	}
+	if nu.Bucket != "b" || nu.Key != "k" || nu.Version != 2 {
+		t.Errorf("bad error: %+v", nu)
+	}
+}
+
+func TestRegisterUpgrade(t *testing.T) {
+	c := &Client{}
+	b := c.Bucket("schema")
+	noop := func(v []byte) ([]byte, error) { return v, nil }
+
+	b.RegisterUpgrade(1, noop)
+	cf := c.conf(ustr("schema"))
+	first := cf.schema
+	if first == nil || first.cur != 2 || first.wb {
+		t.Fatalf("bad schema: %+v", first)
+	}
+	b.RegisterUpgrade(0, noop)
+	b.SetWriteBack(true)
+	cf = c.conf(ustr("schema"))
+	if cf.schema.cur != 2 || len(cf.schema.ups) != 2 || !cf.schema.wb {
+		t.Errorf("bad schema: %+v", cf.schema)
+	}
+	// earlier readers keep their copy
+	if len(first.ups) != 1 || first.wb {
+		t.Error("schema was modified in place")
+	}
+	if c.conf(ustr("other")).schema != nil {
+		t.Error("schema leaked to another bucket")
+	}
+
+	// a cleared bucket doesn't
+	// inherit the default schema
+	c.cfg.dflt.schema, c.cfg.dflt.schm = first, 1
+	b.ClearUpgrades()
+	if c.conf(ustr("schema")).schema != nil {
+		t.Error("cleared bucket has a schema")
+	}
+	if c.conf(ustr("other")).schema != first {
+		t.Error("other buckets should use the default schema")
+	}
+}
@@ -4,9 +4,14 @@ import (
 	"bytes"
 	"github.com/philhofer/rkive/rpbc"
 	"strconv"
+	"time"
 	"unsafe"
 )
 
+// usermeta keys with this prefix are
+// managed by the client (see: Info.AddMeta)
+var metaPrefix = []byte("rkive-")
+
 // unsafe string-to-byte
 // only use this when 's' has the same scope
 // as the returned byte slice, and there are guarantees
@@ -65,12 +70,21 @@ type ObjectM interface {
 	Merge(o Object)
 }
 
-// sibling merge - object should be Store()d after call
-func handleMerge(om ObjectM, ct []*rpbc.RpbContent) error {
+// sibling merge - object should be Store()d after call.
+// tombstones are skipped, since writing the merged object
+// with the shared vclock supersedes them. returns ErrDeleted
+// if every sibling is a tombstone. metadata is merged
+// according to the bucket's policy.
+func handleMerge(om ObjectM, ct []*rpbc.RpbContent, cf *bucketConf) error {
 	var err error
-	for i, ctt := range ct {
-		if i == 0 {
-			err = readContent(om, ctt)
+	first := true
+	for _, ctt := range ct {
+		if ctt.GetDeleted() {
+			continue
+		}
+		if first {
+			first = false
+			err = readContent(om, ctt, cf)
 			if err != nil {
 				return err
 			}
@@ -79,18 +93,24 @@ func handleMerge(om ObjectM, ct []*rpbc.RpbContent) error {
 
 		// read into new empty
 		nom := om.NewEmpty()
-		err = readContent(nom, ctt)
+		nom.Info().key = append(nom.Info().key[0:0], om.Info().key...)
+		nom.Info().bucket = append(nom.Info().bucket[0:0], om.Info().bucket...)
+		err = readContent(nom, ctt, cf)
 		nom.Info().vclock = append(nom.Info().vclock[0:0], ctt.Vtag...)
 		if err != nil {
 			return err
 		}
 		om.Merge(nom)
+		MergeInfo(om.Info(), nom.Info(), cf.meta)
 
 		// transfer vclocks if we didn't have one before
 		if len(om.Info().vclock) == 0 && len(nom.Info().vclock) > 0 {
 			om.Info().vclock = append(om.Info().vclock, nom.Info().vclock...)
 		}
 	}
+	if first {
+		return ErrDeleted
+	}
 	return nil
 }
 
@@ -104,45 +124,160 @@ func handleMerge(om ObjectM, ct []*rpbc.RpbContent) error {
 // Calls to Fetch(), Push(), Store(), New(),
 // etc. will changes the contents of this struct.
 type Info struct {
-	key    []byte          // key
-	bucket []byte          // bucket
-	links  []*rpbc.RpbLink // Links
-	idxs   []*rpbc.RpbPair // Indexes
-	meta   []*rpbc.RpbPair // Meta
-	ctype  []byte          // Content-Type
-	vclock []byte          // Vclock
+	key     []byte          // key
+	bucket  []byte          // bucket
+	links   []*rpbc.RpbLink // Links
+	idxs    []*rpbc.RpbPair // Indexes
+	meta    []*rpbc.RpbPair // Meta
+	ctype   []byte          // Content-Type
+	charset []byte          // Charset
+	cenc    []byte          // Content-Encoding
+	vtag    []byte          // Vtag
+	vclock  []byte          // Vclock
+	lmod    uint32          // Last-Modified (s)
+	lmodus  uint32          // Last-Modified (us)
 }
 
 func readHeader(o Object, ctnt *rpbc.RpbContent) {
 	o.Info().ctype = append(o.Info().ctype[0:0], ctnt.ContentType...)
+	o.Info().charset = append(o.Info().charset[0:0], ctnt.Charset...)
+	o.Info().cenc = append(o.Info().cenc[0:0], ctnt.ContentEncoding...)
+	o.Info().vtag = append(o.Info().vtag[0:0], ctnt.Vtag...)
+	o.Info().lmod = ctnt.GetLastMod()
+	o.Info().lmodus = ctnt.GetLastModUsecs()
 	o.Info().links = append(o.Info().links[0:0], ctnt.Links...)
 	o.Info().idxs = append(o.Info().idxs[0:0], ctnt.Indexes...)
 	o.Info().meta = append(o.Info().meta[0:0], ctnt.Usermeta...)
+	// encrypted values keep their
+	// encoding in usermeta
+	if enc := get(&ctnt.Usermeta, ustr(MetaCodec)); enc != nil {
+		o.Info().cenc = append(o.Info().cenc[0:0], enc...)
+	}
 }
 
 // read into 'o' from content
-func readContent(o Object, ctnt *rpbc.RpbContent) error {
+func readContent(o Object, ctnt *rpbc.RpbContent, cf *bucketConf) error {
 	if ctnt.GetDeleted() {
 		return ErrDeleted
 	}
 	readHeader(o, ctnt)
-	return o.Unmarshal(ctnt.Value)
+	err := verify(o, ctnt.Value, ctnt.Usermeta)
+	if err != nil {
+		return err
+	}
+	v, err := decrypt(ctnt.Value, ctnt.Usermeta, cf.keys, o.Info().bucket, o.Info().key)
+	if err != nil {
+		return err
+	}
+	v, err = decompress(v, o.Info().cenc)
+	if err != nil {
+		return err
+	}
+	v, err = upgrade(o, v, ctnt.Usermeta, cf.schema)
+	if err != nil {
+		return err
+	}
+	return o.Unmarshal(v)
 }
 
-// write into content from 'o'
-func writeContent(o Object, ctnt *rpbc.RpbContent) error {
-	var err error
-	ctnt.Value, err = o.Marshal()
+// write into content from 'o', which
+// is to be stored at 'bucket'/'key'
+func writeContent(o Object, ctnt *rpbc.RpbContent, bucket []byte, key []byte, cf *bucketConf) error {
+	v, err := o.Marshal()
+	if err != nil {
+		return err
+	}
+	// managed usermeta is re-generated on every write
+	ctnt.Usermeta = ctnt.Usermeta[0:0]
+	for _, p := range o.Info().meta {
+		if !bytes.HasPrefix(p.Key, metaPrefix) {
+			ctnt.Usermeta = append(ctnt.Usermeta, p)
+		}
+	}
+	if cf.schema != nil {
+		ctnt.Usermeta = addVersion(cf.schema, ctnt.Usermeta)
+	}
+	var enc []byte
+	v, enc, err = compress(v, o.Info().cenc, cf)
 	if err != nil {
 		return err
 	}
+	if cf.keys != nil {
+		v, ctnt.Usermeta, err = encrypt(v, ctnt.Usermeta, cf.keys, bucket, key)
+		if err != nil {
+			return err
+		}
+		// the stored bytes are ciphertext, so
+		// the encoding can't be advertised
+		if len(enc) > 0 {
+			ctnt.Usermeta = append(ctnt.Usermeta, &rpbc.RpbPair{
+				Key:   []byte(MetaCodec),
+				Value: enc,
+			})
+			enc = nil
+		}
+	}
+	// the checksum covers the stored bytes, so
+	// it reveals nothing about encrypted values
+	if cf.sums > 0 {
+		ctnt.Usermeta = addChecksum(v, ctnt.Usermeta)
+	}
+	ctnt.Value = v
 	ctnt.ContentType = append(ctnt.ContentType[0:0], o.Info().ctype...)
+	ctnt.Charset = optbytes(ctnt.Charset, o.Info().charset)
+	ctnt.ContentEncoding = optbytes(ctnt.ContentEncoding, enc)
 	ctnt.Links = append(ctnt.Links[0:0], o.Info().links...)
-	ctnt.Usermeta = append(ctnt.Usermeta[0:0], o.Info().meta...)
 	ctnt.Indexes = append(ctnt.Indexes[0:0], o.Info().idxs...)
 	return nil
 }
 
+// copyInfo makes 'dst' a copy of 'src'
+// that shares no memory with it
+func copyInfo(dst *Info, src *Info) {
+	*dst = Info{
+		key:     append([]byte(nil), src.key...),
+		bucket:  append([]byte(nil), src.bucket...),
+		idxs:    copyPairs(src.idxs),
+		meta:    copyPairs(src.meta),
+		ctype:   append([]byte(nil), src.ctype...),
+		charset: append([]byte(nil), src.charset...),
+		cenc:    append([]byte(nil), src.cenc...),
+		vtag:    append([]byte(nil), src.vtag...),
+		vclock:  append([]byte(nil), src.vclock...),
+		lmod:    src.lmod,
+		lmodus:  src.lmodus,
+	}
+	for _, l := range src.links {
+		dst.links = append(dst.links, &rpbc.RpbLink{
+			Bucket: append([]byte(nil), l.Bucket...),
+			Key:    append([]byte(nil), l.Key...),
+			Tag:    append([]byte(nil), l.Tag...),
+		})
+	}
+}
+
+func copyPairs(l []*rpbc.RpbPair) []*rpbc.RpbPair {
+	if l == nil {
+		return nil
+	}
+	out := make([]*rpbc.RpbPair, len(l))
+	for i, p := range l {
+		out[i] = &rpbc.RpbPair{
+			Key:   append([]byte(nil), p.Key...),
+			Value: append([]byte(nil), p.Value...),
+		}
+	}
+	return out
+}
+
+// copy an optional field; empty is omitted
+func optbytes(dst []byte, src []byte) []byte {
+	if len(src) == 0 {
+		return nil
+	}
+	return append(dst[0:0], src...)
+}
+
 func set(l *[]*rpbc.RpbPair, key, value []byte) {
 	if l == nil || len(*l) == 0 {
 		goto add
@@ -175,18 +310,19 @@ func get(l *[]*rpbc.RpbPair, key []byte) []byte {
 }
 
 func add(l *[]*rpbc.RpbPair, key, value []byte) bool {
-	if l == nil || len(*l) == 0 {
-		goto add
-	}
+	// keys may have more than one value
+	found := false
 	for _, item := range *l {
 		if bytes.Equal(key, item.Key) {
 			if bytes.Equal(value, item.Value) {
 				return true
 			}
-			return false
+			found = true
 		}
 	}
-add:
+	if found {
+		return false
+	}
 	*l = append(*l, &rpbc.RpbPair{
 		Key:   key,
 		Value: value,
@@ -207,6 +343,58 @@ func del(l *[]*rpbc.RpbPair, key []byte) {
 	}
 }
 
+// delete every pair with this key
+func delAll(l *[]*rpbc.RpbPair, key []byte) {
+	out := (*l)[:0]
+	for _, item := range *l {
+		if !bytes.Equal(key, item.Key) {
+			out = append(out, item)
+		}
+	}
+	for i := len(out); i < len(*l); i++ {
+		(*l)[i] = nil
+	}
+	*l = out
+}
+
+// every value with this key
+func values(l *[]*rpbc.RpbPair, key []byte) [][]byte {
+	var out [][]byte
+	for _, item := range *l {
+		if bytes.Equal(key, item.Key) {
+			out = append(out, item.Value)
+		}
+	}
+	return out
+}
+
+// add a key-value pair unless it exists; returns
+// whether or not the pair was added
+func addValue(l *[]*rpbc.RpbPair, key, value []byte) bool {
+	for _, item := range *l {
+		if bytes.Equal(key, item.Key) && bytes.Equal(value, item.Value) {
+			return false
+		}
+	}
+	*l = append(*l, &rpbc.RpbPair{
+		Key:   key,
+		Value: value,
+	})
+	return true
+}
+
+// delete one key-value pair
+func delValue(l *[]*rpbc.RpbPair, key, value []byte) {
+	for i, item := range *l {
+		if bytes.Equal(key, item.Key) && bytes.Equal(value, item.Value) {
+			copy((*l)[i:], (*l)[i+1:])
+			(*l)[len(*l)-1] = nil
+			*l = (*l)[:len(*l)-1]
+			return
+		}
+	}
+}
+
 func all(l *[]*rpbc.RpbPair) [][2]string {
 	nl := len(*l)
 	if nl == 0 {
@@ -235,6 +423,37 @@ func (in *Info) SetContentType(s string) { in.ctype = []byte(s) }
 // Vclock is the vector clock value as a string
 func (in *Info) Vclock() string { return string(in.vclock) }
 
+// Vtag is the vtag of this particular
+// value of the object, as assigned by Riak.
+// Siblings have distinct vtags.
+func (in *Info) Vtag() string { return string(in.vtag) }
+
+// LastModified is the time at which the
+// object was last written, as recorded by
+// Riak. It is the zero time if the object
+// has not been read from or written to Riak.
+func (in *Info) LastModified() time.Time {
+	if in.lmod == 0 && in.lmodus == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(in.lmod), int64(in.lmodus)*1000)
+}
+
+// Charset is the character set
+func (in *Info) Charset() string { return string(in.charset) }
+
+// SetCharset sets the character set
+// to 's'.
+func (in *Info) SetCharset(s string) { in.charset = []byte(s) }
+
+// ContentEncoding is the content-encoding
+func (in *Info) ContentEncoding() string { return string(in.cenc) }
+
+// SetContentEncoding sets the content-encoding
+// to 's'. If 's' names a registered codec, the
+// value is compressed with it on write. (See: RegisterCodec)
+func (in *Info) SetContentEncoding(s string) { in.cenc = []byte(s) }
+
 // format key as key_bin
 func fmtbin(key string) []byte {
 	kl := len(key)
@@ -274,22 +493,32 @@ func (in *Info) AddIndexInt(key string, value int64) bool {
 	return add(&in.idxs, fmtint(key), ustr(strconv.FormatInt(value, 10)))
 }
 
-// Set sets a key-value pair in an Indexes object
+// Set sets a key-value pair in an Indexes object,
+// replacing every existing value of the index
 func (in *Info) SetIndex(key string, value string) {
-	set(&in.idxs, fmtbin(key), []byte(value))
+	kv := fmtbin(key)
+	delAll(&in.idxs, kv)
+	addValue(&in.idxs, kv, []byte(value))
 }
 
-// SetIndexInt sets a integer secondary index value
+// SetIndexInt sets a integer secondary index value,
+// replacing every existing value of the index
 func (in *Info) SetIndexInt(key string, value int64) {
-	set(&in.idxs, fmtint(key), ustr(strconv.FormatInt(value, 10)))
+	kv := fmtint(key)
+	delAll(&in.idxs, kv)
+	addValue(&in.idxs, kv, strconv.AppendInt(nil, value, 10))
 }
 
-// Get gets a key-value pair in an indexes object
+// Get gets a key-value pair in an indexes object.
+// If the index has more than one value, the first
+// one is returned. (See: IndexValues)
 func (in *Info) GetIndex(key string) (val string) {
 	return string(get(&in.idxs, fmtbin(key)))
 }
 
-// GetIndexInt gets an integer index value
+// GetIndexInt gets an integer index value. If the
+// index has more than one value, the first one is
+// returned. (See: IndexIntValues)
 func (in *Info) GetIndexInt(key string) *int64 {
 	bts := get(&in.idxs, fmtint(key))
 	if bts == nil {
@@ -299,15 +528,69 @@ func (in *Info) GetIndexInt(key string) *int64 {
 	return &val
 }
 
-// RemoveIndex removes a key from the object
+// RemoveIndex removes every value of
+// an index from the object
 func (in *Info) RemoveIndex(key string) {
-	del(&in.idxs, fmtbin(key))
+	delAll(&in.idxs, fmtbin(key))
 }
 
-// RemoveIndexInt removes an integer index key
-// from an object
+// RemoveIndexInt removes every value of an
+// integer index from the object
 func (in *Info) RemoveIndexInt(key string) {
-	del(&in.idxs, fmtint(key))
+	delAll(&in.idxs, fmtint(key))
+}
+
+// AddIndexValue adds a value to an index, which
+// may have any number of distinct values. It returns
+// false if the index already had this value.
+func (in *Info) AddIndexValue(key string, value string) bool {
+	return addValue(&in.idxs, fmtbin(key), []byte(value))
+}
+
+// AddIndexIntValue adds a value to an integer
+// index. (See: AddIndexValue)
+func (in *Info) AddIndexIntValue(key string, value int64) bool {
+	return addValue(&in.idxs, fmtint(key), strconv.AppendInt(nil, value, 10))
+}
+
+// IndexValues returns every value of an index,
+// in the order in which they were added.
+func (in *Info) IndexValues(key string) []string {
+	vals := values(&in.idxs, fmtbin(key))
+	if vals == nil {
+		return nil
+	}
+	out := make([]string, len(vals))
+	for i, v := range vals {
+		out[i] = string(v)
+	}
+	return out
+}
+
+// IndexIntValues returns every value
+// of an integer index.
+func (in *Info) IndexIntValues(key string) []int64 {
+	vals := values(&in.idxs, fmtint(key))
+	if vals == nil {
+		return nil
+	}
+	out := make([]int64, len(vals))
+	for i, v := range vals {
+		out[i], _ = strconv.ParseInt(string(v), 10, 64)
+	}
+	return out
+}
+
+// RemoveIndexValue removes one value from an index,
+// leaving its other values in place.
+func (in *Info) RemoveIndexValue(key string, value string) {
+	delValue(&in.idxs, fmtbin(key), []byte(value))
+}
+
+// RemoveIndexIntValue removes one value
+// from an integer index.
+func (in *Info) RemoveIndexIntValue(key string, value int64) {
+	delValue(&in.idxs, fmtint(key), strconv.AppendInt(nil, value, 10))
 }
 
 // Indexes returns a list of all of the
@@ -322,14 +605,27 @@ func (in *Info) Indexes() [][2]string {
 }
 
 // AddMeta conditionally adds a key-value pair
-// if it didn't exist already
+// if it didn't exist already. Keys that begin
+// with "rkive-" are reserved for metadata managed
+// by the client (checksums, encryption, etc.); AddMeta
+// returns false for them, and they are never written.
 func (in *Info) AddMeta(key string, value string) bool {
+	if bytes.HasPrefix(ustr(key), metaPrefix) {
+		return false
+	}
 	return add(&in.meta, []byte(key), []byte(value))
 }
 
-// SetMeta sets a key-value pair
-func (in *Info) SetMeta(key string, value string) {
+// SetMeta sets a key-value pair, and returns
+// whether or not it was set. Keys that begin
+// with "rkive-" are reserved, so SetMeta returns
+// false for them. (See: AddMeta)
+func (in *Info) SetMeta(key string, value string) bool {
+	if bytes.HasPrefix(ustr(key), metaPrefix) {
+		return false
+	}
 	set(&in.meta, []byte(key), []byte(value))
+	return true
 }
 
 // GetMeta gets a meta value
@@ -346,7 +642,7 @@ func (in *Info) RemoveMeta(key string) {
 // Metas returns all of the metadata
 // key-value pairs. (Key first, then value.)
 func (in *Info) Metas() [][2]string {
-	return all(&in.idxs)
+	return all(&in.meta)
 }
 
 // AddLink adds a link conditionally. It returns true
@@ -1,7 +1,9 @@
 package rkive
 
 import (
+	"github.com/philhofer/rkive/rpbc"
 	"testing"
+	"time"
 	"unsafe"
 )
 
@@ -59,6 +61,17 @@ func TestAddRemoveLink(t *testing.T) {
 	}
 }
 
+func TestMetas(t *testing.T) {
+	info := Info{}
+	info.AddIndex("idx", "i")
+	info.AddMeta("owner", "joe")
+
+	m := info.Metas()
+	if len(m) != 1 || m[0] != [2]string{"owner", "joe"} {
+		t.Errorf("Metas: %v", m)
+	}
+}
+
 func TestAddRemoveIndex(t *testing.T) {
 	info := Info{}
 
@@ -101,3 +114,130 @@ func TestAddRemoveIndex(t *testing.T) {
 		t.Errorf("Expected nil; got %d", *ival)
 	}
 }
+
+func TestMultiValueIndex(t *testing.T) {
+	info := Info{}
+
+	if !info.AddIndexValue("tag", "a") || !info.AddIndexValue("tag", "b") || !info.AddIndexValue("tag", "c") {
+		t.Error("AddIndexValue should add distinct values")
+	}
+	if info.AddIndexValue("tag", "b") {
+		t.Error("AddIndexValue added a duplicate value")
+	}
+	vals := info.IndexValues("tag")
+	if len(vals) != 3 || vals[0] != "a" || vals[1] != "b" || vals[2] != "c" {
+		t.Errorf("Values: %v", vals)
+	}
+	if !info.AddIndex("tag", "c") || info.AddIndex("tag", "d") {
+		t.Error("AddIndex should accept existing pairs and reject new values")
+	}
+
+	info.RemoveIndexValue("tag", "b")
+	vals = info.IndexValues("tag")
+	if len(vals) != 2 || vals[0] != "a" || vals[1] != "c" {
+		t.Errorf("Values: %v", vals)
+	}
+
+	info.SetIndex("tag", "z")
+	vals = info.IndexValues("tag")
+	if len(vals) != 1 || vals[0] != "z" {
+		t.Errorf("SetIndex should replace every value; got %v", vals)
+	}
+	info.AddIndexValue("tag", "y")
+	info.RemoveIndex("tag")
+	if info.IndexValues("tag") != nil {
+		t.Errorf("RemoveIndex should remove every value; got %v", info.IndexValues("tag"))
+	}
+
+	info.AddIndexIntValue("score", 5)
+	info.AddIndexIntValue("score", -5)
+	ivals := info.IndexIntValues("score")
+	if len(ivals) != 2 || ivals[0] != 5 || ivals[1] != -5 {
+		t.Errorf("Int values: %v", ivals)
+	}
+	info.RemoveIndexIntValue("score", 5)
+	ivals = info.IndexIntValues("score")
+	if len(ivals) != 1 || ivals[0] != -5 {
+		t.Errorf("Int values: %v", ivals)
+	}
+
+	// values survive a round-trip
+	info.AddIndexValue("tag", "a")
+	info.AddIndexValue("tag", "b")
+	ctnt := &rpbc.RpbContent{}
+	err := writeContent(&Blob{RiakInfo: info}, ctnt, nil, nil, &bucketConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &Blob{}
+	err = readContent(out, ctnt, &bucketConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals = out.Info().IndexValues("tag")
+	if len(vals) != 2 || vals[0] != "a" || vals[1] != "b" {
+		t.Errorf("Values after round-trip: %v", vals)
+	}
+}
+
+func TestContentMetadata(t *testing.T) {
+	lmod, lmodus := uint32(1414000000), uint32(250000)
+	z, err := gzipCodec{}.Compress([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctnt := &rpbc.RpbContent{
+		Value:           z,
+		ContentType:     []byte("text/plain"),
+		Charset:         []byte("utf-8"),
+		ContentEncoding: []byte("gzip"),
+		Vtag:            []byte("abcdef"),
+		LastMod:         &lmod,
+		LastModUsecs:    &lmodus,
+	}
+
+	b := &Blob{}
+	err = readContent(b, ctnt, &bucketConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b.Content) != "hello" {
+		t.Errorf("Content: %q", b.Content)
+	}
+	if b.Info().Charset() != "utf-8" {
+		t.Errorf("Charset: %q", b.Info().Charset())
+	}
+	if b.Info().ContentEncoding() != "gzip" {
+		t.Errorf("ContentEncoding: %q", b.Info().ContentEncoding())
+	}
+	if b.Info().Vtag() != "abcdef" {
+		t.Errorf("Vtag: %q", b.Info().Vtag())
+	}
+	want := time.Unix(1414000000, 250000000)
+	if !b.Info().LastModified().Equal(want) {
+		t.Errorf("LastModified: %s; expected %s", b.Info().LastModified(), want)
+	}
+
+	b.Info().SetCharset("latin-1")
+	b.Info().SetContentEncoding("")
+
+	out := &rpbc.RpbContent{}
+	err = writeContent(b, out, nil, nil, &bucketConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out.Charset) != "latin-1" {
+		t.Errorf("Charset: %q", out.Charset)
+	}
+	if out.ContentEncoding != nil {
+		t.Errorf("ContentEncoding should be omitted; got %q", out.ContentEncoding)
+	}
+	if out.Vtag != nil || out.LastMod != nil {
+		t.Error("Vtag and LastMod should not be written")
+	}
+
+	if !(&Info{}).LastModified().IsZero() {
+		t.Error("LastModified should be zero for an empty Info")
+	}
+}
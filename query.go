@@ -0,0 +1,434 @@
+package rkive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/philhofer/rkive/rpbc"
+	"github.com/philhofer/rkive/tuple"
+	"io"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrNoCondition is returned when an IndexQuery
+	// is run before its index condition has been set.
+	ErrNoCondition = errors.New("index query has no condition")
+
+	// ErrBadRegexQuery is returned when an IndexQuery
+	// with a term regex is not a Range or KeyRange query.
+	ErrBadRegexQuery = errors.New("term regex requires a binary range query")
+)
+
+// Continuation is an opaque token that marks the
+// end of one page of index query results. It can be
+// stored and passed to (*IndexQuery).After to fetch
+// the next page. The empty Continuation means that
+// there are no more results.
+type Continuation string
+
+// IndexQuery is a secondary index query. Queries are
+// built with (*Bucket).Query, followed by exactly one
+// condition (Match, MatchInt, Range, RangeInt, KeyRange
+// or AllKeys) and any number of options, and executed
+// with Run:
+//
+//   res, err := bucket.Query().Match("email", addr).MaxResults(20).Run()
+//
+// Queries can be re-used: after each Run, the
+// continuation of the result can be passed to After
+// in order to run the query for the next page.
+type IndexQuery struct {
+	c   *Client
+	req rpbc.RpbIndexReq
+	set bool // condition has been set
+}
+
+// Query begins a secondary index
+// query on the bucket.
+func (b *Bucket) Query() *IndexQuery { return b.c.Query(b.nm) }
+
+// Query begins a secondary index
+// query on a bucket.
+func (c *Client) Query(bucket string) *IndexQuery {
+	q := &IndexQuery{c: c}
+	q.req.Bucket = []byte(bucket)
+	q.req.Stream = &ptrTrue
+	return q
+}
+
+func (q *IndexQuery) cond(index string, suffix string, qtype rpbc.RpbIndexReq_IndexQueryType) *IndexQuery {
+	q.req.Index = append(append(q.req.Index[0:0], index...), suffix...)
+	q.req.Qtype = &qtype
+	q.req.Key, q.req.RangeMin, q.req.RangeMax = nil, nil, nil
+	q.set = true
+	return q
+}
+
+// Match matches the objects for which the
+// "_bin" index 'index' is equal to 'value'.
+func (q *IndexQuery) Match(index string, value string) *IndexQuery {
+	q.cond(index, "_bin", rpbc.RpbIndexReq_eq)
+	q.req.Key = []byte(value)
+	return q
+}
+
+// MatchInt matches the objects for which the
+// "_int" index 'index' is equal to 'value'.
+func (q *IndexQuery) MatchInt(index string, value int64) *IndexQuery {
+	q.cond(index, "_int", rpbc.RpbIndexReq_eq)
+	q.req.Key = strconv.AppendInt(nil, value, 10)
+	return q
+}
+
+// RangeInt matches the objects for which the "_int"
+// index 'index' is between 'min' and 'max', inclusive.
+func (q *IndexQuery) RangeInt(index string, min int64, max int64) *IndexQuery {
+	q.cond(index, "_int", rpbc.RpbIndexReq_range)
+	q.req.RangeMin = strconv.AppendInt(nil, min, 10)
+	q.req.RangeMax = strconv.AppendInt(nil, max, 10)
+	return q
+}
+
+// Range matches the objects for which the "_bin"
+// index 'index' is between 'min' and 'max', inclusive.
+// Terms are compared byte-wise.
+func (q *IndexQuery) Range(index string, min string, max string) *IndexQuery {
+	q.cond(index, "_bin", rpbc.RpbIndexReq_range)
+	q.req.RangeMin = []byte(min)
+	q.req.RangeMax = []byte(max)
+	return q
+}
+
+// TuplePrefix matches the objects for which the "_bin"
+// index 'index' is a packed tuple that begins with the
+// elements of 'prefix'. (See: package tuple)
+func (q *IndexQuery) TuplePrefix(index string, prefix tuple.Tuple) *IndexQuery {
+	min, max := prefix.Range()
+	return q.Range(index, min, max)
+}
+
+// TupleRange matches the objects for which the "_bin"
+// index 'index' is a packed tuple between 'lo' and 'hi',
+// inclusive, including the tuples that begin with 'hi'.
+func (q *IndexQuery) TupleRange(index string, lo tuple.Tuple, hi tuple.Tuple) *IndexQuery {
+	min, max := tuple.Between(lo, hi)
+	return q.Range(index, min, max)
+}
+
+// KeyRange matches the objects with keys between
+// 'start' and 'end', inclusive, using the special
+// "$key" index.
+func (q *IndexQuery) KeyRange(start string, end string) *IndexQuery {
+	q.cond("$key", "", rpbc.RpbIndexReq_range)
+	q.req.RangeMin = []byte(start)
+	q.req.RangeMax = []byte(end)
+	return q
+}
+
+// AllKeys matches every object in the bucket,
+// using the special "$bucket" index. This is much
+// less expensive than listing the keys in the bucket.
+func (q *IndexQuery) AllKeys() *IndexQuery {
+	q.cond("$bucket", "", rpbc.RpbIndexReq_eq)
+	q.req.Key = append([]byte(nil), q.req.Bucket...)
+	return q
+}
+
+// KeyRange begins a query for the keys in the
+// bucket between 'start' and 'end', inclusive.
+// (See: (*IndexQuery).KeyRange)
+func (b *Bucket) KeyRange(start string, end string) *IndexQuery {
+	return b.Query().KeyRange(start, end)
+}
+
+// AllKeys begins a query for every key in
+// the bucket. (See: (*IndexQuery).AllKeys)
+func (b *Bucket) AllKeys() *IndexQuery { return b.Query().AllKeys() }
+
+// TermRegex filters the results of a Range query
+// on the server side, so that only the terms that
+// match the regular expression 're' are returned.
+// The expression is evaluated by Riak, using Erlang's
+// (PCRE) regular expression syntax. The empty string
+// removes the filter.
+func (q *IndexQuery) TermRegex(re string) *IndexQuery {
+	if re == "" {
+		q.req.TermRegex = nil
+	} else {
+		q.req.TermRegex = []byte(re)
+	}
+	return q
+}
+
+// MaxResults limits the number of results returned
+// by each Run. Results are paginated when MaxResults
+// is set; see After. An 'n' <= 0 removes the limit.
+func (q *IndexQuery) MaxResults(n int) *IndexQuery {
+	if n <= 0 {
+		q.req.MaxResults = nil
+		return q
+	}
+	mx := uint32(n)
+	q.req.MaxResults = &mx
+	return q
+}
+
+// After sets the continuation from which the next
+// Run begins. The empty Continuation starts at
+// the first page.
+func (q *IndexQuery) After(c Continuation) *IndexQuery {
+	if c == "" {
+		q.req.Continuation = nil
+	} else {
+		q.req.Continuation = []byte(c)
+	}
+	return q
+}
+
+// PaginationSort determines whether or not results are
+// sorted when the query isn't paginated. (Paginated
+// results are always sorted.)
+func (q *IndexQuery) PaginationSort(on bool) *IndexQuery {
+	q.req.PaginationSort = &on
+	return q
+}
+
+// ReturnTerms determines whether or not the result
+// includes the index term that matched each key.
+// (See: (*IndexQueryRes).Entries)
+func (q *IndexQuery) ReturnTerms(on bool) *IndexQuery {
+	q.req.ReturnTerms = &on
+	return q
+}
+
+// Timeout sets the timeout of the query. The client
+// waits at least as long for each response.
+func (q *IndexQuery) Timeout(d time.Duration) *IndexQuery {
+	ms := uint32(d / time.Millisecond)
+	q.req.Timeout = &ms
+	return q
+}
+
+// read timeout for the query's responses
+func (q *IndexQuery) rto() time.Duration {
+	return streamTimeout(time.Duration(q.req.GetTimeout()) * time.Millisecond)
+}
+
+// Run executes the query. If the result is one page
+// of a larger result, its Continuation is non-empty.
+func (q *IndexQuery) Run() (*IndexQueryRes, error) {
+	if !q.set {
+		return nil, ErrNoCondition
+	}
+	if q.req.TermRegex != nil && !q.binRange() {
+		return nil, ErrBadRegexQuery
+	}
+	queryres := &IndexQueryRes{
+		c:      q.c,
+		bucket: q.req.Bucket,
+	}
+	stream, err := q.c.streamReqTimeout(&q.req, 25, q.rto())
+	if err != nil {
+		return nil, err
+	}
+	res := &rpbc.RpbIndexResp{}
+	done := false
+	for !done {
+		var code byte
+		done, code, err = stream.unmarshal(res)
+		if err != nil {
+			return queryres, err
+		}
+		if code != 26 {
+			if !done {
+				stream.abort()
+			}
+			return queryres, ErrUnexpectedResponse
+		}
+		err = queryres.add(&q.req, res)
+		if err != nil {
+			if !done {
+				stream.abort()
+			}
+			return queryres, err
+		}
+		res.Reset()
+	}
+	return queryres, nil
+}
+
+// binRange returns whether or not the
+// query is a range over binary terms
+func (q *IndexQuery) binRange() bool {
+	if q.req.GetQtype() != rpbc.RpbIndexReq_range {
+		return false
+	}
+	return bytes.HasSuffix(q.req.Index, []byte("_bin")) || string(q.req.Index) == "$key"
+}
+
+// IndexEntry is a key returned from an index
+// query along with the index term that it matched.
+type IndexEntry struct {
+	Term    string // matched term
+	IntTerm int64  // matched term, for "_int" indexes
+	Key     string // object key
+}
+
+// Tuple decodes the term as a packed tuple.
+// (See: package tuple)
+func (e IndexEntry) Tuple() (tuple.Tuple, error) { return tuple.Unpack(e.Term) }
+
+// add one response frame to the result
+func (i *IndexQueryRes) add(req *rpbc.RpbIndexReq, res *rpbc.RpbIndexResp) error {
+	ents, err := appendEntries(nil, req, res)
+	if err != nil {
+		return err
+	}
+	for _, e := range ents {
+		i.keys = append(i.keys, []byte(e.Key))
+	}
+	if req.GetReturnTerms() {
+		i.terms = append(i.terms, ents...)
+	}
+	if len(res.Continuation) > 0 {
+		i.cont = Continuation(res.Continuation)
+	}
+	return nil
+}
+
+// append the entries in 'res' to 'dst'. terms
+// are only set when the request asked for them.
+func appendEntries(dst []IndexEntry, req *rpbc.RpbIndexReq, res *rpbc.RpbIndexResp) ([]IndexEntry, error) {
+	isint := bytes.HasSuffix(req.Index, []byte("_int"))
+	for _, k := range res.Keys {
+		e := IndexEntry{Key: string(k)}
+		// riak only returns terms for range
+		// queries; the term of a match is known
+		if req.GetReturnTerms() {
+			e.Term = string(req.Key)
+			if isint {
+				e.IntTerm, _ = strconv.ParseInt(e.Term, 10, 64)
+			}
+		}
+		dst = append(dst, e)
+	}
+	for _, p := range res.Results {
+		e := IndexEntry{Term: string(p.Key), Key: string(p.Value)}
+		if isint {
+			var err error
+			e.IntTerm, err = strconv.ParseInt(e.Term, 10, 64)
+			if err != nil {
+				return dst, err
+			}
+		}
+		dst = append(dst, e)
+	}
+	return dst, nil
+}
+
+// IndexStream is a streaming index query result.
+// Keys are returned as each response frame arrives,
+// so the whole result is never held in memory.
+// An IndexStream holds a connection until it has been
+// read to the end or closed.
+type IndexStream struct {
+	c      *Client
+	bucket string
+	s      *streamRes
+	req    rpbc.RpbIndexReq // fields used to decode entries
+	res    rpbc.RpbIndexResp
+	ents   []IndexEntry
+	cont   Continuation
+	done   bool
+	err    error
+}
+
+// Stream executes the query, returning the
+// results as they arrive. (See: IndexStream)
+func (q *IndexQuery) Stream() (*IndexStream, error) {
+	if !q.set {
+		return nil, ErrNoCondition
+	}
+	if q.req.TermRegex != nil && !q.binRange() {
+		return nil, ErrBadRegexQuery
+	}
+	s, err := q.c.streamReqTimeout(&q.req, 25, q.rto())
+	if err != nil {
+		return nil, err
+	}
+	is := &IndexStream{c: q.c, bucket: string(q.req.Bucket), s: s}
+	is.req.Index = append([]byte(nil), q.req.Index...)
+	is.req.Key = append([]byte(nil), q.req.Key...)
+	is.req.ReturnTerms = q.req.ReturnTerms
+	return is, nil
+}
+
+// Next returns the next key in the stream.
+// It returns io.EOF once all of the keys have
+// been returned.
+func (s *IndexStream) Next() (string, error) {
+	e, err := s.NextEntry()
+	return e.Key, err
+}
+
+// NextEntry is like Next, but it also returns
+// the matched term if the query was made
+// with ReturnTerms set.
+func (s *IndexStream) NextEntry() (IndexEntry, error) {
+	for len(s.ents) == 0 {
+		if s.done {
+			return IndexEntry{}, s.err
+		}
+		s.res.Reset()
+		var code byte
+		s.done, code, s.err = s.s.unmarshal(&s.res)
+		if s.err == nil && code != 26 {
+			s.err = ErrUnexpectedResponse
+		}
+		if s.err == nil {
+			s.ents, s.err = appendEntries(s.ents[0:0], &s.req, &s.res)
+			if len(s.res.Continuation) > 0 {
+				s.cont = Continuation(s.res.Continuation)
+			}
+		}
+		if s.err != nil {
+			if !s.done {
+				s.s.abort()
+			}
+			s.done = true
+			s.ents = nil
+			return IndexEntry{}, s.err
+		}
+		if s.done {
+			s.err = io.EOF
+		}
+	}
+	e := s.ents[0]
+	s.ents = s.ents[1:]
+	return e, nil
+}
+
+// Continuation returns the token for the next page
+// of results once the stream has been read to the end.
+// (See: IndexQuery)
+func (s *IndexStream) Continuation() Continuation { return s.cont }
+
+// Close stops the stream. It is safe to call
+// Close more than once, and after the stream
+// has been read to the end.
+func (s *IndexStream) Close() {
+	if !s.done {
+		s.s.abort()
+		s.done, s.err = true, io.EOF
+	}
+	s.ents = nil
+}
+
+// Fetch fetches the objects in the stream concurrently,
+// as their keys arrive. The stream is closed when the
+// returned iterator is finished or closed. (See: MultiFetchContext)
+func (s *IndexStream) Fetch(ctx context.Context, o Duplicator, opts *FetchOpts) *FetchIter {
+	return fetchIter(ctx, s.c, s.bucket, o, opts, s.Next, s.Close)
+}
@@ -0,0 +1,166 @@
+package rkive
+
+import (
+	"github.com/philhofer/rkive/rpbc"
+	"github.com/philhofer/rkive/tuple"
+	"testing"
+	"time"
+)
+
+func TestIndexQueryBuilder(t *testing.T) {
+	c := &Client{}
+	q := c.Bucket("b").Query()
+	if _, err := q.Run(); err != ErrNoCondition {
+		t.Errorf("expected ErrNoCondition; got %v", err)
+	}
+
+	q.RangeInt("age", 18, 65).MaxResults(10).After("abc").Timeout(2 * time.Second).PaginationSort(true)
+	if string(q.req.Index) != "age_int" || q.req.GetQtype() != 1 {
+		t.Errorf("bad index %q/%d", q.req.Index, q.req.GetQtype())
+	}
+	if string(q.req.RangeMin) != "18" || string(q.req.RangeMax) != "65" {
+		t.Errorf("bad range %q-%q", q.req.RangeMin, q.req.RangeMax)
+	}
+	if q.req.GetMaxResults() != 10 || string(q.req.Continuation) != "abc" || q.req.GetTimeout() != 2000 || !q.req.GetPaginationSort() {
+		t.Errorf("bad options: %s", q.req.String())
+	}
+	if q.rto() != 3*time.Second {
+		t.Errorf("expected a 3s read timeout; got %s", q.rto())
+	}
+
+	// a new condition replaces the old one
+	q.Match("name", "joe").After("")
+	if string(q.req.Index) != "name_bin" || q.req.GetQtype() != 0 || string(q.req.Key) != "joe" {
+		t.Errorf("bad match: %s", q.req.String())
+	}
+	if q.req.RangeMin != nil || q.req.RangeMax != nil || q.req.Continuation != nil {
+		t.Errorf("stale fields: %s", q.req.String())
+	}
+
+	// negative limits mean no limit
+	q.MaxResults(-1)
+	if q.req.MaxResults != nil {
+		t.Errorf("MaxResults(-1) set a limit of %d", q.req.GetMaxResults())
+	}
+}
+
+func TestIndexQueryTerms(t *testing.T) {
+	q := (&Client{}).Query("b").RangeInt("ts", 0, 100).ReturnTerms(true)
+	res := &IndexQueryRes{}
+	err := res.add(&q.req, &rpbc.RpbIndexResp{
+		Results: []*rpbc.RpbPair{
+			{Key: []byte("10"), Value: []byte("a")},
+			{Key: []byte("20"), Value: []byte("b")},
+		},
+		Continuation: []byte("next"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents := res.Entries()
+	if len(ents) != 2 || res.Len() != 2 {
+		t.Fatalf("expected 2 entries; got %v", ents)
+	}
+	if ents[1] != (IndexEntry{Term: "20", IntTerm: 20, Key: "b"}) {
+		t.Errorf("bad entry: %+v", ents[1])
+	}
+	if res.Continuation() != "next" {
+		t.Errorf("bad continuation %q", res.Continuation())
+	}
+
+	err = res.add(&q.req, &rpbc.RpbIndexResp{
+		Results: []*rpbc.RpbPair{{Key: []byte("x"), Value: []byte("c")}},
+	})
+	if err == nil {
+		t.Error("expected an error for a non-integer term")
+	}
+
+	// match queries get the term from the query
+	q.Match("name", "joe")
+	res = &IndexQueryRes{}
+	err = res.add(&q.req, &rpbc.RpbIndexResp{Keys: [][]byte{[]byte("k")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Entries()) != 1 || res.Entries()[0] != (IndexEntry{Term: "joe", Key: "k"}) {
+		t.Errorf("bad entries: %+v", res.Entries())
+	}
+}
+
+func TestIndexQueryRegex(t *testing.T) {
+	q := (&Client{}).Query("b").Range("name", "a", "m").TermRegex("^b.*n$").ReturnTerms(true)
+	if string(q.req.Index) != "name_bin" || string(q.req.RangeMin) != "a" || string(q.req.RangeMax) != "m" {
+		t.Errorf("bad range: %s", q.req.String())
+	}
+	if string(q.req.TermRegex) != "^b.*n$" {
+		t.Errorf("bad regex: %q", q.req.TermRegex)
+	}
+
+	// regexes only apply to binary ranges
+	q.RangeInt("age", 1, 2)
+	if _, err := q.Run(); err != ErrBadRegexQuery {
+		t.Errorf("expected ErrBadRegexQuery; got %v", err)
+	}
+	q.Match("name", "bob")
+	if _, err := q.Run(); err != ErrBadRegexQuery {
+		t.Errorf("expected ErrBadRegexQuery; got %v", err)
+	}
+	q.TermRegex("")
+	if q.req.TermRegex != nil {
+		t.Error("regex wasn't removed")
+	}
+}
+
+func TestIndexQuerySpecial(t *testing.T) {
+	b := (&Client{}).Bucket("things")
+	q := b.AllKeys()
+	if string(q.req.Index) != "$bucket" || string(q.req.Key) != "things" || q.req.GetQtype() != 0 {
+		t.Errorf("bad $bucket query: %s", q.req.String())
+	}
+	q = b.KeyRange("a", "b").TermRegex("^a")
+	if string(q.req.Index) != "$key" || string(q.req.RangeMin) != "a" || string(q.req.RangeMax) != "b" || q.req.GetQtype() != 1 {
+		t.Errorf("bad $key query: %s", q.req.String())
+	}
+	if !q.binRange() {
+		t.Error("$key ranges should allow a term regex")
+	}
+}
+
+func TestIndexQueryTuple(t *testing.T) {
+	q := (&Client{}).Query("b").TuplePrefix("tenant_ts", tuple.Tuple{"acme"})
+	min, max := tuple.Tuple{"acme"}.Range()
+	if string(q.req.Index) != "tenant_ts_bin" || string(q.req.RangeMin) != min || string(q.req.RangeMax) != max {
+		t.Errorf("bad tuple prefix query: %s", q.req.String())
+	}
+
+	ts := time.Unix(1414000000, 0).UTC()
+	q.TupleRange("tenant_ts", tuple.Tuple{"acme", ts}, tuple.Tuple{"acme", ts.Add(time.Hour)}).ReturnTerms(true)
+	res := &IndexQueryRes{}
+	err := res.add(&q.req, &rpbc.RpbIndexResp{
+		Results: []*rpbc.RpbPair{{Key: []byte(tuple.Pack("acme", ts)), Value: []byte("k")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp, err := res.Entries()[0].Tuple()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tp) != 2 || tp[0] != "acme" || !tp[1].(time.Time).Equal(ts) {
+		t.Errorf("bad tuple: %v", tp)
+	}
+}
+
+func TestIndexQueryTimeout(t *testing.T) {
+	c, _, stop := fakeRiak(t, map[byte]protom{
+		25: slowFrame{&rpbc.RpbIndexResp{Keys: [][]byte{[]byte("k")}, Done: &ptrTrue}, 1500 * time.Millisecond},
+	})
+	defer stop()
+	res, err := c.Query("b").Match("x", "1").Timeout(5 * time.Second).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Len() != 1 || !res.Contains("k") {
+		t.Errorf("expected k; got %v", res.Keys())
+	}
+}
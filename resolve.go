@@ -0,0 +1,200 @@
+package rkive
+
+import (
+	"bytes"
+	"github.com/philhofer/rkive/rpbc"
+	"reflect"
+)
+
+// A Resolver chooses the value of an object
+// from its siblings. It should return one of
+// s.Values(), or a new object (of the same type)
+// holding a merged value. Returning a nil Object
+// indicates that the object should be treated as
+// deleted.
+//
+// Resolvers can be set for a client (SetResolver) or
+// for a bucket (Bucket.SetResolver). A configured Resolver
+// is used by Fetch, Update, Store and Push whenever siblings
+// are encountered, and it takes precedence over ObjectM.Merge.
+// When a write creates siblings, the object being written is
+// one of the candidates; if the resolver chooses a tombstone,
+// the object is written anyway.
+// Object types that do not implement Duplicator must be pointers
+// to structs in order to be resolved.
+type Resolver func(s *Siblings) (Object, error)
+
+// SetResolver sets the default sibling resolver
+// for every bucket. Passing nil removes the default.
+func (c *Client) SetResolver(r Resolver) {
+	c.setConf(nil, func(bc *bucketConf) { bc.resolve, bc.rslv = r, tristate(r != nil) })
+}
+
+// SetResolver sets the sibling resolver for the
+// bucket, which overrides the client's resolver.
+// Passing nil removes the resolver for the bucket
+// even if the client has a default.
+func (b *Bucket) SetResolver(r Resolver) {
+	b.c.setConf(&b.nm, func(bc *bucketConf) { bc.resolve, bc.rslv = r, tristate(r != nil) })
+}
+
+// LastWriteWins chooses the sibling with the
+// most recent last-modified time. If that sibling
+// is a tombstone, the object is treated as deleted.
+// Ties are broken by vtag.
+func LastWriteWins(s *Siblings) (Object, error) {
+	v := newest(s.vals)
+	for _, t := range s.tombs {
+		if v == nil || newer(t, v.Info()) {
+			return nil, nil
+		}
+	}
+	return v, nil
+}
+
+// NewestLive chooses the most recently modified
+// sibling that is not a tombstone. Ties are
+// broken by vtag.
+func NewestLive(s *Siblings) (Object, error) {
+	return newest(s.vals), nil
+}
+
+// VtagOrder chooses the sibling with the greatest
+// vtag. The choice is arbitrary, but deterministic:
+// every client chooses the same value from the
+// same set of siblings.
+func VtagOrder(s *Siblings) (Object, error) {
+	var out Object
+	for _, v := range s.vals {
+		if out == nil || bytes.Compare(v.Info().vtag, out.Info().vtag) > 0 {
+			out = v
+		}
+	}
+	return out, nil
+}
+
+// is 'a' newer than 'b'?
+func newer(a *Info, b *Info) bool {
+	if a.lmod != b.lmod {
+		return a.lmod > b.lmod
+	}
+	if a.lmodus != b.lmodus {
+		return a.lmodus > b.lmodus
+	}
+	return bytes.Compare(a.vtag, b.vtag) > 0
+}
+
+func newest(vals []Object) Object {
+	var out Object
+	for _, v := range vals {
+		if out == nil || newer(v.Info(), out.Info()) {
+			out = v
+		}
+	}
+	return out
+}
+
+// newEmpty returns an empty object of the
+// same type as 'o', or nil if it can't
+func newEmpty(o Object) Object {
+	if d, ok := o.(Duplicator); ok {
+		return d.NewEmpty()
+	}
+	t := reflect.TypeOf(o)
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	out, _ := reflect.New(t.Elem()).Interface().(Object)
+	return out
+}
+
+// resolve the siblings in 'res' into 'o'
+// using the bucket's resolver
+func resolve(c *Client, cf *bucketConf, o Object, bucket []byte, key []byte, res *rpbc.RpbGetResp) error {
+	s, err := readSiblings(c, cf, o, bucket, key, res)
+	if err != nil {
+		return err
+	}
+	chosen, err := cf.resolve(s)
+	if err != nil {
+		return err
+	}
+	if chosen == nil {
+		return tombstone(o, bucket, key, res.Vclock)
+	}
+	if chosen != o {
+		err = assign(o, chosen)
+		if err != nil {
+			return err
+		}
+	}
+	o.Info().bucket = append(o.Info().bucket[0:0], bucket...)
+	o.Info().key = append(o.Info().key[0:0], key...)
+	o.Info().vclock = append(o.Info().vclock[0:0], res.Vclock...)
+	return nil
+}
+
+// assign copies the value and info
+// of 'chosen' into 'o'
+func assign(o Object, chosen Object) error {
+	bts, err := chosen.Marshal()
+	if err != nil {
+		return err
+	}
+	// the info comes first, since Unmarshal
+	// can read it (see: Wrapped)
+	copyInfo(o.Info(), chosen.Info())
+	return o.Unmarshal(bts)
+}
+
+// resolveWrite resolves the siblings created by a
+// write of 'o'. 'o' is offered to the resolver along
+// with the stored siblings, and it keeps its value
+// unless the resolver chooses another one. (Choosing
+// a tombstone keeps 'o', since 'o' is being written.)
+// Either way, 'o' takes the vclock of the siblings,
+// so that the write can be retried.
+func (c *Client) resolveWrite(o Object, cf *bucketConf) error {
+	s, err := c.siblings(o, o.Info().bucket, o.Info().key, nil, cf)
+	if err != nil {
+		return err
+	}
+	s.vals = append([]Object{o}, s.vals...)
+	chosen, err := cf.resolve(s)
+	if err != nil {
+		return err
+	}
+	if chosen != nil && chosen != o {
+		err = assign(o, chosen)
+		if err != nil {
+			return err
+		}
+	}
+	o.Info().vclock = append(o.Info().vclock[0:0], s.vclock...)
+	return nil
+}
+
+// handleSiblings reads a response with multiple
+// siblings into 'o', using the configured Resolver
+// or ObjectM.Merge. The object should be stored
+// after the call in order to repair the siblings.
+func (c *Client) handleSiblings(o Object, bucket []byte, key []byte, res *rpbc.RpbGetResp) error {
+	cf := c.conf(bucket)
+	if cf.resolve != nil {
+		err := resolve(c, &cf, o, bucket, key, res)
+		if _, ok := err.(*ErrMultipleResponses); !ok {
+			return err
+		}
+	}
+	if om, ok := o.(ObjectM); ok {
+		om.Info().key = append(om.Info().key[0:0], key...)
+		om.Info().bucket = append(om.Info().bucket[0:0], bucket...)
+		om.Info().vclock = append(om.Info().vclock[0:0], res.Vclock...)
+		err := handleMerge(om, res.Content, &cf)
+		if err == ErrDeleted {
+			return tombstone(o, bucket, key, res.Vclock)
+		}
+		return err
+	}
+	return handleMultiple(len(res.Content), string(key), string(bucket))
+}
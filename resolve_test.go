@@ -0,0 +1,203 @@
+package rkive
+
+import (
+	"errors"
+	"github.com/philhofer/rkive/rpbc"
+	"testing"
+)
+
+// siblings response; 'deleted' marks tombstones
+func sibResp(lmods []uint32, vals []string, deleted []bool) *rpbc.RpbGetResp {
+	res := &rpbc.RpbGetResp{Vclock: []byte("vclock")}
+	for i := range vals {
+		lm := lmods[i]
+		res.Content = append(res.Content, &rpbc.RpbContent{
+			Value:   []byte(vals[i]),
+			Vtag:    []byte{byte('a' + i)},
+			LastMod: &lm,
+			Deleted: &deleted[i],
+		})
+	}
+	return res
+}
+
+func TestResolvers(t *testing.T) {
+	res := sibResp(
+		[]uint32{100, 300, 200},
+		[]string{"old", "", "middle"},
+		[]bool{false, true, false},
+	)
+	s, err := readSiblings(nil, &bucketConf{}, &Blob{}, []byte("b"), []byte("k"), res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 2 || s.Deleted() != 1 {
+		t.Fatalf("Len: %d; Deleted: %d", s.Len(), s.Deleted())
+	}
+
+	v, _ := LastWriteWins(s)
+	if v != nil {
+		t.Errorf("LastWriteWins should choose the tombstone; got %q", v.(*Blob).Content)
+	}
+
+	v, _ = NewestLive(s)
+	if v == nil || string(v.(*Blob).Content) != "middle" {
+		t.Errorf("NewestLive: %v", v)
+	}
+
+	v, _ = VtagOrder(s)
+	if v == nil || v.Info().Vtag() != "c" {
+		t.Errorf("VtagOrder: %v", v)
+	}
+}
+
+func TestResolveInto(t *testing.T) {
+	res := sibResp(
+		[]uint32{100, 200},
+		[]string{"first", "second"},
+		[]bool{false, false},
+	)
+	b := &Blob{}
+	err := resolve(nil, &bucketConf{resolve: NewestLive}, b, []byte("b"), []byte("k"), res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b.Content) != "second" {
+		t.Errorf("Content: %q", b.Content)
+	}
+	if b.Info().Vclock() != "vclock" || b.Info().Key() != "k" || b.Info().Bucket() != "b" {
+		t.Errorf("bad info: %+v", b.Info())
+	}
+
+	err = resolve(nil, &bucketConf{resolve: LastWriteWins}, b, []byte("b"), []byte("k"), sibResp(
+		[]uint32{100, 200},
+		[]string{"first", ""},
+		[]bool{false, true},
+	))
+	if !errors.Is(err, ErrDeleted) {
+		t.Errorf("expected ErrDeleted; got %v", err)
+	}
+}
+
+func TestResolveWrapped(t *testing.T) {
+	ctnt := func(u *wrapUser, lmod uint32) *rpbc.RpbContent {
+		c := &rpbc.RpbContent{}
+		err := writeContent(Wrap(u, JSON), c, nil, nil, &bucketConf{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.LastMod = &lmod
+		return c
+	}
+	res := &rpbc.RpbGetResp{Vclock: []byte("vclock")}
+	res.Content = append(res.Content,
+		ctnt(&wrapUser{Name: "a", Email: "a@x", Owner: "alice"}, 100),
+		ctnt(&wrapUser{Name: "b", Email: "b@x", Owner: "bob"}, 200),
+	)
+
+	u := &wrapUser{}
+	w := Wrap(u, JSON)
+	err := resolve(nil, &bucketConf{resolve: NewestLive}, w, []byte("b"), []byte("k"), res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "b" || u.Email != "b@x" || u.Owner != "bob" || u.ID != "k" {
+		t.Errorf("resolved to %+v", u)
+	}
+	if w.Info().GetIndex("email") != "b@x" || w.Info().GetMeta("owner") != "bob" {
+		t.Errorf("indexes %v; meta %v", w.Info().Indexes(), w.Info().Metas())
+	}
+}
+
+func TestResolveCopiesInfo(t *testing.T) {
+	res := &rpbc.RpbGetResp{Vclock: []byte("vclock")}
+	for _, v := range []string{"a", "b"} {
+		b := &Blob{Content: []byte(v)}
+		b.Info().SetMeta("color", v)
+		b.Info().AddIndex("tag", v)
+		b.Info().AddLink("parent", "people", v)
+		c := &rpbc.RpbContent{}
+		if err := writeContent(b, c, nil, nil, &bucketConf{}); err != nil {
+			t.Fatal(err)
+		}
+		res.Content = append(res.Content, c)
+	}
+	var chosen Object
+	pick := func(s *Siblings) (Object, error) {
+		chosen = s.Values()[1]
+		return chosen, nil
+	}
+	o := &Blob{}
+	err := resolve(nil, &bucketConf{resolve: pick}, o, []byte("b"), []byte("k"), res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// changes to the discarded sibling
+	// mustn't show up in the result,
+	// even if they are made in place
+	chosen.Info().meta[0].Value[0] = 'x'
+	chosen.Info().links[0].Key[0] = 'x'
+	chosen.Info().SetMeta("color", "x")
+	chosen.Info().SetIndex("tag", "x")
+	chosen.Info().SetLink("parent", "people", "x")
+	if o.Info().GetMeta("color") != "b" || o.Info().GetIndex("tag") != "b" {
+		t.Errorf("meta %v; indexes %v", o.Info().Metas(), o.Info().Indexes())
+	}
+	if _, k := o.Info().GetLink("parent"); k != "b" {
+		t.Errorf("link to %q", k)
+	}
+}
+
+func TestBucketResolverCleared(t *testing.T) {
+	c := &Client{}
+	c.SetResolver(LastWriteWins)
+	b := c.Bucket("merged")
+	b.SetResolver(nil)
+
+	if cf := c.conf([]byte("merged")); cf.resolve != nil {
+		t.Error("bucket resolver should be cleared")
+	}
+	if cf := c.conf([]byte("other")); cf.resolve == nil {
+		t.Error("other buckets should use the client resolver")
+	}
+
+	// a bucket resolver can be set again
+	b.SetResolver(NewestLive)
+	if cf := c.conf([]byte("merged")); cf.resolve == nil {
+		t.Error("bucket resolver should be set")
+	}
+}
+
+func TestResolveWrite(t *testing.T) {
+	c, _, stop := fakeRiak(t, map[byte]protom{
+		9: sibResp(
+			[]uint32{100, 300},
+			[]string{"other", ""},
+			[]bool{false, true},
+		),
+	})
+	defer stop()
+
+	// the tombstone is newest, but
+	// the write mustn't fail or lose
+	// its value
+	o := &Blob{Content: []byte("mine")}
+	o.Info().bucket, o.Info().key = []byte("b"), []byte("k")
+	err := c.resolveWrite(o, &bucketConf{resolve: LastWriteWins})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(o.Content) != "mine" || o.Info().Vclock() != "vclock" {
+		t.Errorf("content %q; vclock %q", o.Content, o.Info().Vclock())
+	}
+
+	// other siblings can still be chosen
+	err = c.resolveWrite(o, &bucketConf{resolve: VtagOrder})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(o.Content) != "other" || o.Info().Key() != "k" {
+		t.Errorf("content %q; key %q", o.Content, o.Info().Key())
+	}
+}
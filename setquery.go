@@ -0,0 +1,505 @@
+package rkive
+
+import (
+	"context"
+	"errors"
+	"github.com/philhofer/rkive/rpbc"
+	"io"
+	"sort"
+)
+
+const (
+	// MaxSetStreams is the maximum number of index
+	// queries in a SetQuery that can stream at once.
+	// Each one holds a connection until the SetQuery
+	// is finished, so the limit leaves connections
+	// for other requests (e.g. (*SetStream).Fetch).
+	MaxSetStreams = maxConns / 2
+
+	// DefaultSetBuffer is the default maximum number
+	// of keys buffered for each range query in a SetQuery.
+	DefaultSetBuffer = 100000
+)
+
+var (
+	// ErrMixedBuckets is returned when the index
+	// queries in a SetQuery are not all on the
+	// same bucket.
+	ErrMixedBuckets = errors.New("set query spans more than one bucket")
+
+	// ErrTooManyStreams is returned when a SetQuery
+	// would stream more than MaxSetStreams index queries
+	// at once.
+	ErrTooManyStreams = errors.New("set query streams too many index queries")
+
+	// ErrSetBufferFull is returned when a range query in
+	// a SetQuery matches more keys than the query's buffer.
+	// (See: (*SetQuery).Buffer)
+	ErrSetBufferFull = errors.New("range query in set query matched too many keys")
+)
+
+// Set is a set of keys described by index
+// queries. Set is implemented by *IndexQuery
+// and *SetQuery.
+type Set interface {
+	open(so *setOpen) (keyIter, error)
+	leaves(dst []*IndexQuery) []*IndexQuery
+}
+
+// state for opening the queries in a set
+type setOpen struct {
+	c       *Client
+	bucket  string
+	buf     int // max keys buffered per range query
+	streams int // number of streams opened
+}
+
+const (
+	opAnd = iota
+	opOr
+	opNot
+)
+
+// SetQuery is a boolean combination of index
+// queries on one bucket, built with And, Or and Not:
+//
+//   red := b.Query().Match("team", "red")
+//   old := b.Query().RangeInt("age", 65, 150)
+//   res, err := And(red, Not(old)).Run()
+//
+// Riak can't combine indexes, so every query is run
+// separately and the keys are merged on the client.
+// Queries that return keys in order (Match, MatchInt,
+// KeyRange and AllKeys) are merged as they stream in,
+// holding one key per query in memory; at most
+// MaxSetStreams of them (including the AllKeys query
+// implied by a Not outside of And) can be combined.
+// Range and RangeInt queries return keys in term order,
+// so their keys are read and sorted before they are merged,
+// up to the limit set by Buffer. MaxResults, After and
+// ReturnTerms are ignored on the queries in a SetQuery.
+type SetQuery struct {
+	op   int
+	sets []Set
+	buf  int
+}
+
+// And matches the keys that are matched by every set.
+func And(sets ...Set) *SetQuery { return &SetQuery{op: opAnd, sets: sets} }
+
+// Or matches the keys that are matched by any set.
+func Or(sets ...Set) *SetQuery { return &SetQuery{op: opOr, sets: sets} }
+
+// Not matches the keys in the bucket that are not
+// matched by 's'. Within And, Not excludes keys from
+// the other sets without reading every key in the bucket.
+func Not(s Set) *SetQuery { return &SetQuery{op: opNot, sets: []Set{s}} }
+
+func (q *SetQuery) leaves(dst []*IndexQuery) []*IndexQuery {
+	for _, s := range q.sets {
+		dst = s.leaves(dst)
+	}
+	return dst
+}
+
+func (q *IndexQuery) leaves(dst []*IndexQuery) []*IndexQuery { return append(dst, q) }
+
+// Buffer sets the maximum number of keys that are
+// buffered for each Range or RangeInt query in the set.
+// Running the query returns ErrSetBufferFull if a range
+// query matches more keys. (The default is DefaultSetBuffer.)
+// Only the setting of the outermost SetQuery is used.
+func (q *SetQuery) Buffer(n int) *SetQuery {
+	q.buf = n
+	return q
+}
+
+func (q *SetQuery) open(so *setOpen) (keyIter, error) {
+	if len(q.sets) == 0 {
+		return nil, ErrNoCondition
+	}
+	switch q.op {
+	case opNot:
+		return openDiff(so, so.c.Query(so.bucket).AllKeys(), q.sets)
+	case opOr:
+		its, err := openAll(so, q.sets)
+		if err != nil {
+			return nil, err
+		}
+		return newOrKeys(its), nil
+	}
+
+	// keys matched by Not are subtracted
+	// from the intersection of the rest
+	var pos, neg []Set
+	for _, s := range q.sets {
+		if n, ok := s.(*SetQuery); ok && n.op == opNot {
+			neg = append(neg, n.sets[0])
+		} else {
+			pos = append(pos, s)
+		}
+	}
+	if len(pos) == 0 {
+		return openDiff(so, so.c.Query(so.bucket).AllKeys(), neg)
+	}
+	var from Set = pos[0]
+	if len(pos) > 1 {
+		from = And(pos...)
+	}
+	if len(neg) == 0 {
+		its, err := openAll(so, pos)
+		if err != nil {
+			return nil, err
+		}
+		return newAndKeys(its), nil
+	}
+	return openDiff(so, from, neg)
+}
+
+// open the keys in 'from' that aren't in any of 'not'
+func openDiff(so *setOpen, from Set, not []Set) (keyIter, error) {
+	its, err := openAll(so, append([]Set{from}, not...))
+	if err != nil {
+		return nil, err
+	}
+	return &diffKeys{a: its[0], b: newOrKeys(its[1:])}, nil
+}
+
+func openAll(so *setOpen, sets []Set) ([]keyIter, error) {
+	its := make([]keyIter, 0, len(sets))
+	for _, s := range sets {
+		it, err := s.open(so)
+		if err != nil {
+			for _, it := range its {
+				it.close()
+			}
+			return nil, err
+		}
+		its = append(its, it)
+	}
+	return its, nil
+}
+
+func (q *IndexQuery) open(so *setOpen) (keyIter, error) {
+	lq := &IndexQuery{c: q.c, req: q.req, set: q.set}
+	lq.req.MaxResults = nil
+	lq.req.Continuation = nil
+	lq.req.ReturnTerms = nil
+	lq.req.PaginationSort = &ptrTrue
+	sorted := lq.req.GetQtype() == rpbc.RpbIndexReq_eq || string(lq.req.Index) == "$key"
+	if sorted {
+		if so.streams >= MaxSetStreams {
+			return nil, ErrTooManyStreams
+		}
+		so.streams++
+	}
+	s, err := lq.Stream()
+	if err != nil {
+		return nil, err
+	}
+	if sorted {
+		return &streamKeys{s: s}, nil
+	}
+	var keys []string
+	for {
+		k, err := s.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) >= so.buf {
+			s.Close()
+			return nil, ErrSetBufferFull
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return &streamKeys{keys: keys}, nil
+}
+
+// scope returns the client and bucket of
+// the queries in the set
+func (q *SetQuery) scope() (*Client, string, error) {
+	lv := q.leaves(nil)
+	if len(lv) == 0 {
+		return nil, "", ErrNoCondition
+	}
+	for _, l := range lv[1:] {
+		if string(l.req.Bucket) != string(lv[0].req.Bucket) {
+			return nil, "", ErrMixedBuckets
+		}
+	}
+	return lv[0].c, string(lv[0].req.Bucket), nil
+}
+
+// Stream executes the query, returning the
+// matching keys in sorted order as they are
+// found. (See: SetStream)
+func (q *SetQuery) Stream() (*SetStream, error) {
+	c, bucket, err := q.scope()
+	if err != nil {
+		return nil, err
+	}
+	so := &setOpen{c: c, bucket: bucket, buf: q.buf}
+	if so.buf <= 0 {
+		so.buf = DefaultSetBuffer
+	}
+	it, err := q.open(so)
+	if err != nil {
+		return nil, err
+	}
+	return &SetStream{c: c, bucket: bucket, it: it}, nil
+}
+
+// Run executes the query, returning all of
+// the matching keys in sorted order.
+func (q *SetQuery) Run() (*IndexQueryRes, error) {
+	s, err := q.Stream()
+	if err != nil {
+		return nil, err
+	}
+	res := &IndexQueryRes{c: s.c, bucket: []byte(s.bucket)}
+	for {
+		k, err := s.Next()
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return res, err
+		}
+		res.keys = append(res.keys, []byte(k))
+	}
+}
+
+// SetStream is a stream of the keys matched
+// by a SetQuery. A SetStream holds one connection
+// for each streamed index query (at most MaxSetStreams)
+// until it has been read to the end or closed.
+type SetStream struct {
+	c      *Client
+	bucket string
+	it     keyIter
+	err    error
+}
+
+// Next returns the next key in the stream.
+// It returns io.EOF once all of the keys have
+// been returned.
+func (k *SetStream) Next() (string, error) {
+	if k.err != nil {
+		return "", k.err
+	}
+	key, err := k.it.next()
+	if err != nil {
+		k.err = err
+		k.it.close()
+	}
+	return key, err
+}
+
+// Close stops the stream. It is safe to call
+// Close more than once, and after the stream
+// has been read to the end.
+func (k *SetStream) Close() {
+	if k.err == nil {
+		k.err = io.EOF
+		k.it.close()
+	}
+}
+
+// Fetch fetches the objects in the stream concurrently,
+// as their keys are found. The stream is closed when the
+// returned iterator is finished or closed. (See: MultiFetchContext)
+func (k *SetStream) Fetch(ctx context.Context, o Duplicator, opts *FetchOpts) *FetchIter {
+	return fetchIter(ctx, k.c, k.bucket, o, opts, k.Next, k.Close)
+}
+
+// keyIter returns distinct keys in sorted
+// order, and io.EOF after the last key
+type keyIter interface {
+	next() (string, error)
+	close()
+}
+
+// streamKeys returns the keys from an index stream
+// that is in key order, or from a sorted slice
+type streamKeys struct {
+	s    *IndexStream
+	keys []string
+	last string
+	some bool
+}
+
+func (k *streamKeys) next() (string, error) {
+	for {
+		var key string
+		if k.s != nil {
+			var err error
+			key, err = k.s.Next()
+			if err != nil {
+				return "", err
+			}
+		} else {
+			if len(k.keys) == 0 {
+				return "", io.EOF
+			}
+			key = k.keys[0]
+			k.keys = k.keys[1:]
+		}
+		// multi-valued index entries
+		// can produce duplicate keys
+		if k.some && key == k.last {
+			continue
+		}
+		k.some, k.last = true, key
+		return key, nil
+	}
+}
+
+func (k *streamKeys) close() {
+	if k.s != nil {
+		k.s.Close()
+	}
+	k.keys = nil
+}
+
+// andKeys is the intersection of its inputs
+type andKeys struct {
+	its  []keyIter
+	head []string
+	ok   []bool
+}
+
+func newAndKeys(its []keyIter) *andKeys {
+	return &andKeys{its: its, head: make([]string, len(its)), ok: make([]bool, len(its))}
+}
+
+func (a *andKeys) next() (string, error) {
+	for {
+		for i, it := range a.its {
+			if !a.ok[i] {
+				var err error
+				a.head[i], err = it.next()
+				if err != nil {
+					return "", err
+				}
+				a.ok[i] = true
+			}
+		}
+		max := a.head[0]
+		for _, h := range a.head[1:] {
+			if h > max {
+				max = h
+			}
+		}
+		// advance every input that is behind
+		eq := true
+		for i, h := range a.head {
+			if h != max {
+				eq = false
+				a.ok[i] = false
+			}
+		}
+		if eq {
+			for i := range a.ok {
+				a.ok[i] = false
+			}
+			return max, nil
+		}
+	}
+}
+
+func (a *andKeys) close() {
+	for _, it := range a.its {
+		it.close()
+	}
+}
+
+// orKeys is the union of its inputs
+type orKeys struct {
+	its  []keyIter
+	head []string
+	ok   []bool
+	done []bool
+}
+
+func newOrKeys(its []keyIter) *orKeys {
+	n := len(its)
+	return &orKeys{its: its, head: make([]string, n), ok: make([]bool, n), done: make([]bool, n)}
+}
+
+func (o *orKeys) next() (string, error) {
+	min, found := "", false
+	for i, it := range o.its {
+		if o.done[i] {
+			continue
+		}
+		if !o.ok[i] {
+			var err error
+			o.head[i], err = it.next()
+			if err == io.EOF {
+				o.done[i] = true
+				continue
+			}
+			if err != nil {
+				return "", err
+			}
+			o.ok[i] = true
+		}
+		if !found || o.head[i] < min {
+			min, found = o.head[i], true
+		}
+	}
+	if !found {
+		return "", io.EOF
+	}
+	for i := range o.its {
+		if o.ok[i] && o.head[i] == min {
+			o.ok[i] = false
+		}
+	}
+	return min, nil
+}
+
+func (o *orKeys) close() {
+	for _, it := range o.its {
+		it.close()
+	}
+}
+
+// diffKeys is the keys in 'a' that are not in 'b'
+type diffKeys struct {
+	a, b  keyIter
+	bhead string
+	bok   bool
+	bdone bool
+}
+
+func (d *diffKeys) next() (string, error) {
+	for {
+		k, err := d.a.next()
+		if err != nil {
+			return "", err
+		}
+		for !d.bdone && (!d.bok || d.bhead < k) {
+			d.bhead, err = d.b.next()
+			if err == io.EOF {
+				d.bdone = true
+			} else if err != nil {
+				return "", err
+			}
+			d.bok = true
+		}
+		if !d.bdone && d.bhead == k {
+			continue
+		}
+		return k, nil
+	}
+}
+
+func (d *diffKeys) close() {
+	d.a.close()
+	d.b.close()
+}
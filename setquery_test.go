@@ -0,0 +1,81 @@
+package rkive
+
+import (
+	"io"
+	"reflect"
+	"testing"
+)
+
+func sortedKeys(keys ...string) keyIter { return &streamKeys{keys: keys} }
+
+func drainKeys(t *testing.T, it keyIter) []string {
+	var out []string
+	for {
+		k, err := it.next()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, k)
+	}
+}
+
+func TestSetQueryMerge(t *testing.T) {
+	and := newAndKeys([]keyIter{
+		sortedKeys("a", "b", "c", "d", "f"),
+		sortedKeys("b", "b", "d", "e", "f"),
+		sortedKeys("a", "b", "d", "f", "g"),
+	})
+	if got := drainKeys(t, and); !reflect.DeepEqual(got, []string{"b", "d", "f"}) {
+		t.Errorf("and: got %v", got)
+	}
+
+	or := newOrKeys([]keyIter{
+		sortedKeys("a", "c"),
+		sortedKeys(),
+		sortedKeys("b", "c", "c", "d"),
+	})
+	if got := drainKeys(t, or); !reflect.DeepEqual(got, []string{"a", "b", "c", "d"}) {
+		t.Errorf("or: got %v", got)
+	}
+
+	diff := &diffKeys{
+		a: sortedKeys("a", "b", "c", "d", "e"),
+		b: newOrKeys([]keyIter{sortedKeys("b"), sortedKeys("d", "z")}),
+	}
+	if got := drainKeys(t, diff); !reflect.DeepEqual(got, []string{"a", "c", "e"}) {
+		t.Errorf("diff: got %v", got)
+	}
+
+	diff = &diffKeys{a: sortedKeys("a", "b"), b: sortedKeys()}
+	if got := drainKeys(t, diff); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("diff with empty set: got %v", got)
+	}
+}
+
+func TestSetQueryScope(t *testing.T) {
+	c := &Client{}
+	q := And(c.Query("a").Match("x", "1"), Not(c.Query("b").Match("y", "2")))
+	if _, err := q.Run(); err != ErrMixedBuckets {
+		t.Errorf("expected ErrMixedBuckets; got %v", err)
+	}
+	if _, err := Or().Run(); err != ErrNoCondition {
+		t.Errorf("expected ErrNoCondition; got %v", err)
+	}
+	if _, err := And(c.Query("a")).Run(); err != ErrNoCondition {
+		t.Errorf("expected ErrNoCondition; got %v", err)
+	}
+}
+
+func TestSetQueryTooManyStreams(t *testing.T) {
+	c := closedClient()
+	so := &setOpen{c: c, bucket: "a", buf: DefaultSetBuffer, streams: MaxSetStreams}
+	if _, err := c.Query("a").Match("x", "1").open(so); err != ErrTooManyStreams {
+		t.Errorf("expected ErrTooManyStreams; got %v", err)
+	}
+	if so.streams != MaxSetStreams {
+		t.Errorf("expected %d streams; got %d", MaxSetStreams, so.streams)
+	}
+}
@@ -0,0 +1,142 @@
+package rkive
+
+import (
+	"github.com/philhofer/rkive/rpbc"
+)
+
+// Siblings is the complete set of values
+// stored at a bucket/key pair. Every value
+// shares the same vclock. Conflicts can be
+// resolved by hand by writing back one of the
+// values (or a merged value) with Resolve.
+type Siblings struct {
+	c      *Client
+	bucket []byte
+	key    []byte
+	vclock []byte
+	vals   []Object
+	tombs  []*Info
+}
+
+// Len returns the number of (non-tombstone) siblings.
+func (s *Siblings) Len() int { return len(s.vals) }
+
+// Values returns every (non-tombstone) sibling. Each
+// value has the same underlying type as the value
+// returned by NewEmpty() on the Duplicator passed to
+// FetchSiblings, and each has its own Info, which holds
+// that sibling's vtag, last-modified time, and metadata.
+func (s *Siblings) Values() []Object { return s.vals }
+
+// Deleted returns the number of tombstone siblings.
+func (s *Siblings) Deleted() int { return len(s.tombs) }
+
+// Tombstones returns the metadata of every
+// tombstone sibling.
+func (s *Siblings) Tombstones() []*Info { return s.tombs }
+
+// Bucket is the bucket of the siblings
+func (s *Siblings) Bucket() string { return string(s.bucket) }
+
+// Key is the key of the siblings
+func (s *Siblings) Key() string { return string(s.key) }
+
+// Vclock is the vclock shared by the siblings
+func (s *Siblings) Vclock() string { return string(s.vclock) }
+
+// Resolve writes 'chosen' back to the database using
+// the vclock shared by the siblings, which replaces
+// all of them. 'chosen' may be one of the values returned
+// by Values(), or a new object containing a merged value.
+// Resolve returns ErrModified if the object has been
+// modified since the siblings were fetched.
+func (s *Siblings) Resolve(chosen Object, opts *WriteOpts) error {
+	chosen.Info().bucket = append(chosen.Info().bucket[0:0], s.bucket...)
+	chosen.Info().key = append(chosen.Info().key[0:0], s.key...)
+	chosen.Info().vclock = append(chosen.Info().vclock[0:0], s.vclock...)
+	return s.c.Push(chosen, opts)
+}
+
+// read every sibling in 'res' into a new
+// value of the same type as 'o'
+func readSiblings(c *Client, cf *bucketConf, o Object, bucket []byte, key []byte, res *rpbc.RpbGetResp) (*Siblings, error) {
+	s := &Siblings{
+		c:      c,
+		bucket: append([]byte(nil), bucket...),
+		key:    append([]byte(nil), key...),
+		vclock: append([]byte(nil), res.Vclock...),
+	}
+	for _, ctnt := range res.Content {
+		if ctnt.GetDeleted() {
+			t := &Blob{}
+			readHeader(t, ctnt)
+			t.RiakInfo.bucket = append(t.RiakInfo.bucket, s.bucket...)
+			t.RiakInfo.key = append(t.RiakInfo.key, s.key...)
+			t.RiakInfo.vclock = append(t.RiakInfo.vclock, s.vclock...)
+			s.tombs = append(s.tombs, t.Info())
+			continue
+		}
+		v := newEmpty(o)
+		if v == nil {
+			return nil, handleMultiple(len(res.Content), string(key), string(bucket))
+		}
+		v.Info().bucket = append(v.Info().bucket[0:0], s.bucket...)
+		v.Info().key = append(v.Info().key[0:0], s.key...)
+		err := readContent(v, ctnt, cf)
+		if err != nil {
+			return nil, err
+		}
+		v.Info().vclock = append(v.Info().vclock[0:0], s.vclock...)
+		s.vals = append(s.vals, v)
+	}
+	return s, nil
+}
+
+// FetchSiblings fetches every sibling stored at
+// the provided bucket and key. Unlike Fetch, it never
+// merges siblings or returns *ErrMultipleResponses. If
+// the object exists but every sibling is a tombstone,
+// FetchSiblings returns an *ErrTombstone.
+func (c *Client) FetchSiblings(d Duplicator, bucket string, key string, opts *ReadOpts) (*Siblings, error) {
+	cf := c.conf(ustr(bucket))
+	s, err := c.siblings(d, []byte(bucket), []byte(key), opts, &cf)
+	if err != nil {
+		return nil, err
+	}
+	if s.Len() == 0 {
+		return nil, tombstone(d.NewEmpty(), s.bucket, s.key, s.vclock)
+	}
+	return s, nil
+}
+
+// siblings fetches every sibling at 'bucket'/'key'
+// into new values of the same type as 'o'
+func (c *Client) siblings(o Object, bucket []byte, key []byte, opts *ReadOpts, cf *bucketConf) (*Siblings, error) {
+	req := &rpbc.RpbGetReq{
+		Bucket:        bucket,
+		Key:           key,
+		Timeout:       &dfltreq,
+		Deletedvclock: &ptrTrue,
+	}
+	parseROpts(req, opts)
+
+	res := gresPop()
+	defer gresPush(res)
+	rescode, err := c.req(req, 9, res)
+	if err != nil {
+		return nil, err
+	}
+	if rescode != 10 {
+		return nil, ErrUnexpectedResponse
+	}
+	if len(res.Content) == 0 && len(res.Vclock) == 0 {
+		return nil, ErrNotFound
+	}
+	return readSiblings(c, cf, o, req.Bucket, req.Key, res)
+}
+
+// FetchSiblings fetches every sibling stored at
+// the specified key. (See: Client.FetchSiblings)
+func (b *Bucket) FetchSiblings(d Duplicator, key string) (*Siblings, error) {
+	return b.c.FetchSiblings(d, b.nm, key, nil)
+}
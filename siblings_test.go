@@ -0,0 +1,68 @@
+// +build riak
+
+package rkive
+
+import (
+	"bytes"
+	"fmt"
+	check "gopkg.in/check.v1"
+	"os"
+	"time"
+)
+
+func (s *riakSuite) TestFetchSiblings(c *check.C) {
+	startt := time.Now()
+	travis := os.Getenv("TRAVIS")
+	wercker := os.Getenv("WERCKER")
+	if travis != "" || wercker != "" {
+		c.Skip("The service doesn't have allow_mult set to true")
+	}
+
+	key := fmt.Sprintf("siblings-%d", time.Now().UnixNano())
+
+	// writes without vclocks create siblings
+	for _, body := range []string{"first", "second"} {
+		err := s.cl.Overwrite(&TestObject{Data: []byte(body)}, "testbucket", key, nil)
+		if err != nil {
+			c.Fatal(err)
+		}
+	}
+
+	sib, err := s.cl.FetchSiblings(&TestObject{}, "testbucket", key, nil)
+	if err != nil {
+		c.Fatal(err)
+	}
+	if sib.Len() != 2 {
+		c.Fatalf("Expected 2 siblings; got %d", sib.Len())
+	}
+	vals := sib.Values()
+	if vals[0].Info().Vtag() == vals[1].Info().Vtag() {
+		c.Error("siblings should have distinct vtags")
+	}
+	for _, v := range vals {
+		if v.Info().Vclock() != sib.Vclock() {
+			c.Error("siblings should share a vclock")
+		}
+		if v.Info().LastModified().IsZero() {
+			c.Error("sibling has no last-modified time")
+		}
+	}
+
+	chosen := vals[0].(*TestObject)
+	err = sib.Resolve(chosen, nil)
+	if err != nil {
+		c.Fatal(err)
+	}
+
+	sib, err = s.cl.FetchSiblings(&TestObject{}, "testbucket", key, nil)
+	if err != nil {
+		c.Fatal(err)
+	}
+	if sib.Len() != 1 {
+		c.Fatalf("Expected 1 value after Resolve; got %d", sib.Len())
+	}
+	if !bytes.Equal(sib.Values()[0].(*TestObject).Data, chosen.Data) {
+		c.Errorf("Expected %q; got %q", chosen.Data, sib.Values()[0].(*TestObject).Data)
+	}
+	s.runtime += time.Since(startt)
+}
@@ -35,9 +35,9 @@ func ctput(c *rpbc.RpbContent) {
 }
 
 // create RpbContent from object
-func ctpop(o Object) (*rpbc.RpbContent, error) {
+func ctpop(o Object, bucket []byte, key []byte, cf *bucketConf) (*rpbc.RpbContent, error) {
 	ctnt := ctntPool.Get().(*rpbc.RpbContent)
-	err := writeContent(o, ctnt)
+	err := writeContent(o, ctnt, bucket, key, cf)
 	return ctnt, err
 }
 
@@ -81,11 +81,29 @@ func parseOpts(opts *WriteOpts, req *rpbc.RpbPutReq) {
 // New writes a new object into the database. If 'key'
 // is non-nil, New will attempt to use that key, and return
 // ErrExists if an object already exists at that key-bucket pair.
-// Riak will assign this object a key if 'key' is nil.
+// Riak will assign this object a key if 'key' is nil, unless
+// the object has a tagged key field (see: Wrap) or the bucket
+// is encrypted. Encrypted values are bound to their keys, so
+// the client generates a random key for them instead.
 func (c *Client) New(o Object, bucket string, key *string, opts *WriteOpts) error {
 	req := rpbc.RpbPutReq{
 		Bucket: []byte(bucket),
 	}
+	cf := c.conf(req.Bucket)
+
+	kd, isKeyed := o.(keyed)
+	if key == nil && isKeyed {
+		if k := kd.riakKey(); k != "" {
+			key = &k
+		}
+	}
+	if key == nil && cf.keys != nil {
+		k, err := randomKey()
+		if err != nil {
+			return err
+		}
+		key = &k
+	}
 
 	// return head
 	req.ReturnHead = &ptrTrue
@@ -97,7 +115,7 @@ func (c *Client) New(o Object, bucket string, key *string, opts *WriteOpts) erro
 		o.Info().key = append(o.Info().key[0:0], req.Key...)
 	}
 	var err error
-	req.Content, err = ctpop(o)
+	req.Content, err = ctpop(o, req.Bucket, req.Key, &cf)
 	if err != nil {
 		return err
 	}
@@ -132,6 +150,9 @@ func (c *Client) New(o Object, bucket string, key *string, opts *WriteOpts) erro
 	if len(res.Key) > 0 {
 		o.Info().key = append(o.Info().key[0:0], res.Key...)
 	}
+	if isKeyed {
+		kd.setRiakKey(o.Info().Key())
+	}
 	hdrput(res)
 	return err
 }
@@ -145,6 +166,7 @@ func (c *Client) Store(o Object, opts *WriteOpts) error {
 		return ErrNoPath
 	}
 	ntry := 0 // merge attempts
+	cf := c.conf(o.Info().bucket)
 
 dostore:
 	req := rpbc.RpbPutReq{
@@ -161,7 +183,7 @@ dostore:
 
 	// write content
 	var err error
-	req.Content, err = ctpop(o)
+	req.Content, err = ctpop(o, req.Bucket, req.Key, &cf)
 	if err != nil {
 		return err
 	}
@@ -178,17 +200,30 @@ dostore:
 		if ntry > maxMerges {
 			return handleMultiple(len(res.GetContent()), o.Info().Key(), o.Info().Bucket())
 		}
+		// resolve if possible; 'o' is one
+		// of the candidates, and it takes
+		// the resolved value
+		if cf.resolve != nil {
+			hdrput(res)
+			err = c.resolveWrite(o, &cf)
+			if err != nil {
+				return err
+			}
+			ntry++
+			goto dostore
+		}
 		// repair if possible
 		if om, ok := o.(ObjectM); ok {
 			hdrput(res)
 			// load the old value(s) into nom
 			nom := om.NewEmpty()
-			err = c.Fetch(nom, om.Info().Bucket(), om.Info().Key(), nil)
+			err = c.fetch(nom, om.Info().Bucket(), om.Info().Key(), nil, false)
 			if err != nil {
 				return err
 			}
 			// merge old values
 			om.Merge(nom)
+			MergeInfo(om.Info(), nom.Info(), cf.meta)
 			om.Info().vclock = nom.Info().vclock
 			ntry++
 			// retry the store
@@ -223,10 +258,11 @@ func (c *Client) Push(o Object, opts *WriteOpts) error {
 	req.IfNotModified = &ptrTrue
 	parseOpts(opts, &req)
 	ntry := 0
+	cf := c.conf(req.Bucket)
 
 dopush:
 	var err error
-	req.Content, err = ctpop(o)
+	req.Content, err = ctpop(o, req.Bucket, req.Key, &cf)
 	if err != nil {
 		return err
 	}
@@ -251,6 +287,20 @@ dopush:
 		return ErrNotFound
 	}
 	if len(res.Content) > 1 {
+		// resolve if possible (see Store)
+		if cf.resolve != nil {
+			hdrput(res)
+			if ntry > maxMerges {
+				return handleMultiple(len(res.Content), o.Info().Key(), o.Info().Bucket())
+			}
+			err = c.resolveWrite(o, &cf)
+			if err != nil {
+				return err
+			}
+			req.Vclock = o.Info().vclock
+			ntry++
+			goto dopush
+		}
 		// repair if possible
 		if om, ok := o.(ObjectM); ok {
 			if ntry > maxMerges {
@@ -258,12 +308,14 @@ dopush:
 			}
 			nom := om.NewEmpty()
 			// fetch carries out the local merge on read
-			err = c.Fetch(nom, om.Info().Bucket(), om.Info().Key(), nil)
+			err = c.fetch(nom, om.Info().Bucket(), om.Info().Key(), nil, false)
 			if err != nil {
 				return err
 			}
 			om.Merge(nom)
+			MergeInfo(om.Info(), nom.Info(), cf.meta)
 			om.Info().vclock = append(om.Info().vclock[0:0], nom.Info().vclock...)
+			req.Vclock = om.Info().vclock
 			ntry++
 			goto dopush
 		} else {
@@ -290,8 +342,9 @@ func (c *Client) Overwrite(o Object, bucket string, key string, opts *WriteOpts)
 
 	parseOpts(opts, &req)
 
+	cf := c.conf(req.Bucket)
 	var err error
-	req.Content, err = ctpop(o)
+	req.Content, err = ctpop(o, req.Bucket, req.Key, &cf)
 	if err != nil {
 		return err
 	}
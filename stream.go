@@ -0,0 +1,523 @@
+package rkive
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultChunkSize is the size of the chunks
+	// written by PutStream and (*Bucket).NewUpload.
+	DefaultChunkSize = 512 * 1024
+
+	// ChunkPrefix begins the key of every chunk.
+	// Keys that begin with ChunkPrefix are reserved:
+	// chunks are stored in the same bucket as their
+	// stream, so they appear in ListKeys and AllKeys
+	// results, where they can be recognized (and
+	// skipped) by their prefix.
+	ChunkPrefix = "rkive-chunk/"
+
+	// chunks are indexed by the key
+	// of the stream they belong to
+	chunkIndex = "rkive-stream"
+)
+
+var (
+	// ErrUploadDone is returned when Write, Commit
+	// or Abort are called on an Upload that has
+	// already been committed or aborted.
+	ErrUploadDone = errors.New("upload already committed or aborted")
+)
+
+// ErrStreamCorrupt is the type of error returned
+// when the content read from a stream does not
+// match the size or hash in its manifest.
+type ErrStreamCorrupt struct {
+	Bucket string
+	Key    string
+	Reason string
+}
+
+func (e *ErrStreamCorrupt) Error() string {
+	return fmt.Sprintf("stream %s/%s is corrupt: %s", e.Bucket, e.Key, e.Reason)
+}
+
+// Manifest describes a value that has been
+// split into chunks by PutStream or an Upload.
+// The manifest is stored at the key of the stream;
+// the chunks are stored in the same bucket under keys
+// derived from the stream key and the upload ID, which
+// begin with ChunkPrefix.
+// Since the manifest is a single object, replacing
+// a stream is atomic: readers see either the old
+// chunks or the new ones.
+type Manifest struct {
+	Upload    string   `json:"upload"`     // upload ID
+	Size      int64    `json:"size"`       // total size in bytes
+	Hash      string   `json:"sha256"`     // hex-encoded SHA-256 of the content
+	ChunkSize int      `json:"chunk_size"` // size of every chunk but the last
+	Chunks    []string `json:"chunks"`     // chunk keys, in order
+	info      Info
+}
+
+// Info implements part of the Object interface.
+func (m *Manifest) Info() *Info { return &m.info }
+
+// Marshal implements part of the Object interface.
+func (m *Manifest) Marshal() ([]byte, error) { return json.Marshal(m) }
+
+// Unmarshal implements part of the Object interface.
+func (m *Manifest) Unmarshal(b []byte) error {
+	m.Chunks = m.Chunks[0:0]
+	return json.Unmarshal(b, m)
+}
+
+// NewEmpty implements part of the Duplicator interface.
+func (m *Manifest) NewEmpty() Object { return &Manifest{} }
+
+// chunk key for stream 'key', upload 'id', chunk 'n'
+func chunkKey(key string, id string, n int) string {
+	return ChunkPrefix + key + "/" + id + "/" + strconv.Itoa(n)
+}
+
+// upload ID of a chunk key
+func chunkUpload(ckey string) string {
+	ckey = ckey[:strings.LastIndex(ckey, "/")]
+	return ckey[strings.LastIndex(ckey, "/")+1:]
+}
+
+func uploadID() (string, error) {
+	var id [8]byte
+	_, err := io.ReadFull(rand.Reader, id[:])
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id[:]), nil
+}
+
+// Upload is a chunked write of a stream. Content
+// written to an Upload is stored in chunks as it
+// is written, and becomes visible to readers when
+// the upload is committed. Uploads that fail before
+// they are committed can be resumed with ResumeUpload.
+// An Upload is not safe for concurrent use.
+type Upload struct {
+	b      *Bucket
+	key    string
+	id     string
+	csize  int
+	buf    []byte
+	chunks []string
+	size   int64
+	sum    hash.Hash
+	done   bool
+}
+
+// NewUpload begins an upload to 'key'
+// with chunks of 'chunkSize' bytes. If
+// 'chunkSize' is not positive, DefaultChunkSize
+// is used.
+func (b *Bucket) NewUpload(key string, chunkSize int) (*Upload, error) {
+	id, err := uploadID()
+	if err != nil {
+		return nil, err
+	}
+	return b.newUpload(key, id, chunkSize), nil
+}
+
+func (b *Bucket) newUpload(key string, id string, chunkSize int) *Upload {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	return &Upload{
+		b:     b,
+		key:   key,
+		id:    id,
+		csize: chunkSize,
+		buf:   make([]byte, 0, chunkSize),
+		sum:   sha256.New(),
+	}
+}
+
+// ResumeUpload resumes an upload that was not committed.
+// The chunks that have already been stored are read back
+// in order to verify them and to rebuild the hash of the
+// content. The content should be written starting at
+// byte offset Size() of the original input.
+func (b *Bucket) ResumeUpload(key string, id string, chunkSize int) (*Upload, error) {
+	u := b.newUpload(key, id, chunkSize)
+	ck := &Blob{}
+	for {
+		ckey := chunkKey(key, id, len(u.chunks))
+		err := b.Fetch(ck, ckey)
+		if err == ErrNotFound || errors.Is(err, ErrDeleted) {
+			return u, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// only complete chunks are kept; a
+		// short chunk is re-written
+		if len(ck.Content) != u.csize {
+			return u, nil
+		}
+		u.sum.Write(ck.Content)
+		u.size += int64(len(ck.Content))
+		u.chunks = append(u.chunks, ckey)
+	}
+}
+
+// ID returns the ID of the upload, which
+// can be passed to ResumeUpload.
+func (u *Upload) ID() string { return u.id }
+
+// Size returns the number of bytes
+// written to the upload.
+func (u *Upload) Size() int64 { return u.size + int64(len(u.buf)) }
+
+// Write implements io.Writer. Chunks are
+// stored as soon as they are full.
+func (u *Upload) Write(p []byte) (int, error) {
+	if u.done {
+		return 0, ErrUploadDone
+	}
+	n := 0
+	for len(p) > 0 {
+		c := copy(u.buf[len(u.buf):u.csize], p)
+		u.buf = u.buf[:len(u.buf)+c]
+		p = p[c:]
+		n += c
+		if len(u.buf) == u.csize {
+			err := u.flush()
+			if err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// ReadFrom implements io.ReaderFrom.
+func (u *Upload) ReadFrom(r io.Reader) (int64, error) {
+	if u.done {
+		return 0, ErrUploadDone
+	}
+	var n int64
+	for {
+		c, err := r.Read(u.buf[len(u.buf):u.csize])
+		u.buf = u.buf[:len(u.buf)+c]
+		n += int64(c)
+		if len(u.buf) == u.csize {
+			if ferr := u.flush(); ferr != nil {
+				return n, ferr
+			}
+		}
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// store the buffered chunk
+func (u *Upload) flush() error {
+	ckey := chunkKey(u.key, u.id, len(u.chunks))
+	err := u.b.writeChunk(ckey, u.key, u.buf)
+	if err != nil {
+		return err
+	}
+	u.sum.Write(u.buf)
+	u.size += int64(len(u.buf))
+	u.chunks = append(u.chunks, ckey)
+	u.buf = u.buf[0:0]
+	return nil
+}
+
+// write a chunk, replacing any existing
+// (partial) chunk at the same key
+func (b *Bucket) writeChunk(ckey string, key string, data []byte) error {
+	ck := &Blob{Content: data}
+	ck.Info().SetContentType("application/octet-stream")
+	ck.Info().AddIndex(chunkIndex, key)
+	err := b.New(ck, &ckey)
+	if err != ErrExists {
+		return err
+	}
+	hd, err := b.c.FetchHead(b.nm, ckey)
+	if err != nil && !errors.Is(err, ErrDeleted) {
+		return err
+	}
+	if hd != nil {
+		ck.Info().vclock = append(ck.Info().vclock[0:0], hd.vclock...)
+	} else {
+		ck.Info().vclock = append(ck.Info().vclock[0:0], err.(*ErrTombstone).Info().vclock...)
+	}
+	ck.Info().bucket = append(ck.Info().bucket[0:0], b.nm...)
+	return b.Store(ck)
+}
+
+// Commit stores the remaining content and writes the
+// manifest, which makes the new content visible to readers.
+// The chunks of the content that the manifest replaces are
+// not removed; see CollectChunks.
+func (u *Upload) Commit() (*Manifest, error) {
+	if u.done {
+		return nil, ErrUploadDone
+	}
+	if len(u.buf) > 0 {
+		err := u.flush()
+		if err != nil {
+			return nil, err
+		}
+	}
+	m := &Manifest{
+		Upload:    u.id,
+		Size:      u.size,
+		Hash:      hex.EncodeToString(u.sum.Sum(nil)),
+		ChunkSize: u.csize,
+		Chunks:    u.chunks,
+	}
+	m.Info().SetContentType("application/json")
+	err := u.b.putManifest(m, u.key)
+	if err != nil {
+		return nil, err
+	}
+	u.done = true
+	return m, nil
+}
+
+// Abort abandons the upload and
+// removes the chunks that it stored.
+func (u *Upload) Abort() error {
+	if u.done {
+		return ErrUploadDone
+	}
+	u.done = true
+	for _, ckey := range u.chunks {
+		err := u.b.DeleteKey(ckey)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write the manifest with a conditional write,
+// so that concurrent commits don't create siblings.
+// Siblings that exist anyway are replaced: the
+// commit being written is the newest of them.
+func (b *Bucket) putManifest(m *Manifest, key string) error {
+	for i := 0; i <= maxMerges; i++ {
+		old := &Manifest{}
+		err := b.Fetch(old, key)
+		if err == ErrNotFound {
+			err = b.New(m, &key)
+			if err == ErrExists {
+				continue
+			}
+			return err
+		}
+		if _, ok := err.(*ErrMultipleResponses); ok {
+			err = b.siblingVclock(old, key)
+		}
+		if err != nil && !errors.Is(err, ErrDeleted) {
+			return err
+		}
+		m.Info().key = append(m.Info().key[0:0], key...)
+		m.Info().bucket = append(m.Info().bucket[0:0], b.nm...)
+		m.Info().vclock = append(m.Info().vclock[0:0], old.Info().vclock...)
+		err = b.Push(m)
+		if _, ok := err.(*ErrMultipleResponses); ok {
+			continue
+		}
+		if err != ErrModified {
+			return err
+		}
+	}
+	return ErrModified
+}
+
+// siblingVclock sets the vclock of 'm' to
+// the vclock shared by the siblings at 'key'
+func (b *Bucket) siblingVclock(m *Manifest, key string) error {
+	s, err := b.FetchSiblings(m, key)
+	if err != nil {
+		// every sibling is a tombstone
+		if t, ok := err.(*ErrTombstone); ok {
+			m.Info().vclock = append(m.Info().vclock[0:0], t.Info().vclock...)
+		}
+		return err
+	}
+	m.Info().vclock = append(m.Info().vclock[0:0], s.vclock...)
+	return nil
+}
+
+// PutStream stores the content of 'r' at 'key' in chunks
+// of DefaultChunkSize bytes. If PutStream fails, it makes
+// an attempt to remove the chunks that it stored. Use
+// NewUpload for uploads that can be resumed.
+func (b *Bucket) PutStream(key string, r io.Reader) error {
+	u, err := b.NewUpload(key, DefaultChunkSize)
+	if err != nil {
+		return err
+	}
+	_, err = u.ReadFrom(r)
+	if err == nil {
+		_, err = u.Commit()
+	}
+	if err != nil && !u.done {
+		u.Abort()
+	}
+	return err
+}
+
+// FetchManifest fetches the manifest of the
+// stream stored at 'key'.
+func (b *Bucket) FetchManifest(key string) (*Manifest, error) {
+	m := &Manifest{}
+	err := b.Fetch(m, key)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// GetStream returns a reader for the stream stored
+// at 'key'. Chunks are fetched as they are read. The
+// size and hash of the content are verified once it has
+// been read in full; a mismatch is reported as an
+// *ErrStreamCorrupt in place of io.EOF.
+func (b *Bucket) GetStream(key string) (io.ReadCloser, error) {
+	m, err := b.FetchManifest(key)
+	if err != nil {
+		return nil, err
+	}
+	return &streamReader{b: b, m: m, sum: sha256.New()}, nil
+}
+
+type streamReader struct {
+	b    *Bucket
+	m    *Manifest
+	ck   Blob
+	cur  []byte
+	next int
+	size int64
+	sum  hash.Hash
+	err  error
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	for len(s.cur) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.next == len(s.m.Chunks) {
+			s.err = s.check()
+			continue
+		}
+		s.err = s.b.Fetch(&s.ck, s.m.Chunks[s.next])
+		if s.err == ErrNotFound || errors.Is(s.err, ErrDeleted) {
+			s.err = s.corrupt("missing chunk " + s.m.Chunks[s.next])
+		}
+		if s.err == nil {
+			s.cur = s.ck.Content
+			s.next++
+		}
+	}
+	n := copy(p, s.cur)
+	s.sum.Write(s.cur[:n])
+	s.size += int64(n)
+	s.cur = s.cur[n:]
+	return n, nil
+}
+
+// verify size and hash
+func (s *streamReader) check() error {
+	if s.size != s.m.Size {
+		return s.corrupt(fmt.Sprintf("read %d bytes; expected %d", s.size, s.m.Size))
+	}
+	if hex.EncodeToString(s.sum.Sum(nil)) != s.m.Hash {
+		return s.corrupt("hash mismatch")
+	}
+	return io.EOF
+}
+
+func (s *streamReader) corrupt(reason string) error {
+	return &ErrStreamCorrupt{
+		Bucket: s.b.nm,
+		Key:    s.m.Info().Key(),
+		Reason: reason,
+	}
+}
+
+func (s *streamReader) Close() error {
+	s.cur = nil
+	if s.err == nil {
+		s.err = errors.New("read of closed stream")
+	}
+	return nil
+}
+
+// CollectChunks removes the chunks stored for 'key'
+// that are not referenced by its current manifest
+// and were last written more than 'grace' ago. Those
+// chunks belong to uploads that were replaced, aborted
+// without cleanup, or never committed. The grace period
+// protects uploads that are still in progress and
+// readers of a manifest that has just been replaced.
+// CollectChunks returns the number of chunks removed.
+func (b *Bucket) CollectChunks(key string, grace time.Duration) (int, error) {
+	live := ""
+	m, err := b.FetchManifest(key)
+	if err == nil {
+		live = m.Upload
+	} else if err != ErrNotFound && !errors.Is(err, ErrDeleted) {
+		return 0, err
+	}
+	ks, err := b.Query().Match(chunkIndex, key).Stream()
+	if err != nil {
+		return 0, err
+	}
+	defer ks.Close()
+	cutoff := time.Now().Add(-grace)
+	n := 0
+	for {
+		ckey, err := ks.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if chunkUpload(ckey) == live {
+			continue
+		}
+		hd, err := b.c.FetchHead(b.nm, ckey)
+		if err == ErrNotFound || errors.Is(err, ErrDeleted) {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		if hd.LastModified().After(cutoff) {
+			continue
+		}
+		err = b.DeleteKey(ckey)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+}
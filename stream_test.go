@@ -0,0 +1,175 @@
+// +build riak
+
+package rkive
+
+import (
+	"bytes"
+	"fmt"
+	check "gopkg.in/check.v1"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+func (s *riakSuite) TestStream(c *check.C) {
+	startt := time.Now()
+	b := s.cl.Bucket("teststream")
+
+	data := make([]byte, 3*DefaultChunkSize+100)
+	rand.Read(data)
+	err := b.PutStream("big", bytes.NewReader(data))
+	if err != nil {
+		c.Fatal(err)
+	}
+
+	m, err := b.FetchManifest("big")
+	if err != nil {
+		c.Fatal(err)
+	}
+	if m.Size != int64(len(data)) || len(m.Chunks) != 4 {
+		c.Errorf("bad manifest: %+v", m)
+	}
+	for _, ckey := range m.Chunks {
+		if !strings.HasPrefix(ckey, ChunkPrefix) {
+			c.Errorf("chunk key %q doesn't begin with %q", ckey, ChunkPrefix)
+		}
+	}
+
+	rd, err := b.GetStream("big")
+	if err != nil {
+		c.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(rd)
+	rd.Close()
+	if err != nil {
+		c.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		c.Error("stream content doesn't match")
+	}
+
+	// overwriting leaves the old chunks
+	// around until they are collected
+	err = b.PutStream("big", bytes.NewReader(data[:100]))
+	if err != nil {
+		c.Fatal(err)
+	}
+	n, err := b.CollectChunks("big", 0)
+	if err != nil {
+		c.Fatal(err)
+	}
+	if n != 4 {
+		c.Errorf("expected 4 chunks to be collected; got %d", n)
+	}
+	rd, err = b.GetStream("big")
+	if err != nil {
+		c.Fatal(err)
+	}
+	out, err = ioutil.ReadAll(rd)
+	if err != nil {
+		c.Fatal(err)
+	}
+	if !bytes.Equal(out, data[:100]) {
+		c.Error("stream content doesn't match")
+	}
+	s.runtime += time.Since(startt)
+}
+
+func (s *riakSuite) TestResumeUpload(c *check.C) {
+	startt := time.Now()
+	b := s.cl.Bucket("teststream")
+
+	data := make([]byte, 2500)
+	rand.Read(data)
+	u, err := b.NewUpload("resumed", 1000)
+	if err != nil {
+		c.Fatal(err)
+	}
+	_, err = u.Write(data[:1500])
+	if err != nil {
+		c.Fatal(err)
+	}
+
+	// pretend the first upload died
+	u, err = b.ResumeUpload("resumed", u.ID(), 1000)
+	if err != nil {
+		c.Fatal(err)
+	}
+	if u.Size() != 1000 {
+		c.Fatalf("expected to resume at 1000; got %d", u.Size())
+	}
+	_, err = u.Write(data[u.Size():])
+	if err != nil {
+		c.Fatal(err)
+	}
+	_, err = u.Commit()
+	if err != nil {
+		c.Fatal(err)
+	}
+
+	rd, err := b.GetStream("resumed")
+	if err != nil {
+		c.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(rd)
+	if err != nil {
+		c.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		c.Error("stream content doesn't match")
+	}
+	s.runtime += time.Since(startt)
+}
+
+func (s *riakSuite) TestStreamManifestSiblings(c *check.C) {
+	startt := time.Now()
+	travis := os.Getenv("TRAVIS")
+	wercker := os.Getenv("WERCKER")
+	if travis != "" || wercker != "" {
+		c.Skip("The service doesn't have allow_mult set to true")
+	}
+	b := s.cl.Bucket("testbucket")
+	key := fmt.Sprintf("stream-siblings-%d", time.Now().UnixNano())
+
+	// writes without vclocks create siblings
+	for _, id := range []string{"first", "second"} {
+		err := s.cl.Overwrite(&Manifest{Upload: id}, "testbucket", key, nil)
+		if err != nil {
+			c.Fatal(err)
+		}
+	}
+	sib, err := b.FetchSiblings(&Manifest{}, key)
+	if err != nil {
+		c.Fatal(err)
+	}
+	if sib.Len() != 2 {
+		c.Skip("no siblings were created")
+	}
+
+	data := make([]byte, 2500)
+	rand.Read(data)
+	u, err := b.NewUpload(key, 1000)
+	if err != nil {
+		c.Fatal(err)
+	}
+	_, err = u.Write(data)
+	if err != nil {
+		c.Fatal(err)
+	}
+	m, err := u.Commit()
+	if err != nil {
+		c.Fatal(err)
+	}
+
+	// the commit replaces every sibling
+	out, err := b.FetchManifest(key)
+	if err != nil {
+		c.Fatal(err)
+	}
+	if out.Upload != m.Upload || out.Size != int64(len(data)) {
+		c.Errorf("bad manifest: %+v", out)
+	}
+	s.runtime += time.Since(startt)
+}
@@ -0,0 +1,214 @@
+// Package tuple implements an order-preserving
+// encoding of tuples for use as "_bin" secondary
+// index terms.
+//
+// Riak compares "_bin" terms byte-wise. Packed tuples
+// compare element-by-element in the same order as the
+// values that they contain, so range queries over
+// compound keys (tenant + timestamp, user + score, etc.)
+// return the expected results. Elements of different
+// types are ordered by type.
+package tuple
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// element type tags, in sort order
+const (
+	tagBytes  = 0x01
+	tagString = 0x02
+	tagInt    = 0x10
+	tagUint   = 0x11
+	tagTime   = 0x12
+	tagFalse  = 0x20
+	tagTrue   = 0x21
+
+	// no element begins with this
+	// byte, so it sorts after every
+	// extension of a prefix
+	tagMax = 0xff
+)
+
+var (
+	// ErrCorrupt is returned by Unpack when
+	// its input is not a packed tuple.
+	ErrCorrupt = errors.New("tuple: corrupt encoding")
+)
+
+// Tuple is an ordered list of elements. Elements
+// may be strings, []byte, signed and unsigned integers
+// of any size, time.Time and bool. Unpack returns
+// signed integers as int64 and unsigned integers as
+// uint64. Times are encoded with nanosecond precision
+// and are returned in UTC.
+type Tuple []interface{}
+
+// Pack returns the encoding of the tuple.
+// Pack panics if an element has an
+// unsupported type.
+func (t Tuple) Pack() string {
+	return string(t.AppendPack(nil))
+}
+
+// AppendPack appends the encoding of
+// the tuple to 'b'. (See: Pack)
+func (t Tuple) AppendPack(b []byte) []byte {
+	for _, e := range t {
+		switch e := e.(type) {
+		case string:
+			b = appendString(append(b, tagString), e)
+		case []byte:
+			b = appendString(append(b, tagBytes), string(e))
+		case int:
+			b = appendInt(b, int64(e))
+		case int8:
+			b = appendInt(b, int64(e))
+		case int16:
+			b = appendInt(b, int64(e))
+		case int32:
+			b = appendInt(b, int64(e))
+		case int64:
+			b = appendInt(b, e)
+		case uint:
+			b = appendUint(append(b, tagUint), uint64(e))
+		case uint8:
+			b = appendUint(append(b, tagUint), uint64(e))
+		case uint16:
+			b = appendUint(append(b, tagUint), uint64(e))
+		case uint32:
+			b = appendUint(append(b, tagUint), uint64(e))
+		case uint64:
+			b = appendUint(append(b, tagUint), e)
+		case time.Time:
+			b = append(b, tagTime)
+			b = appendUint(b, uint64(e.Unix())^(1<<63))
+			var ns [4]byte
+			binary.BigEndian.PutUint32(ns[:], uint32(e.Nanosecond()))
+			b = append(b, ns[:]...)
+		case bool:
+			if e {
+				b = append(b, tagTrue)
+			} else {
+				b = append(b, tagFalse)
+			}
+		default:
+			panic(fmt.Sprintf("tuple: unsupported element type %T", e))
+		}
+	}
+	return b
+}
+
+// Pack packs its arguments as a Tuple.
+func Pack(elems ...interface{}) string { return Tuple(elems).Pack() }
+
+// Range returns the inclusive bounds of the terms
+// of every tuple that begins with the elements of 't'.
+// (Strings must match whole elements; "ab" is not a
+// prefix of "abc".)
+func (t Tuple) Range() (min string, max string) {
+	b := t.AppendPack(nil)
+	return string(b), string(append(b, tagMax))
+}
+
+// Between returns the inclusive bounds of the terms
+// of every tuple from 'lo' through 'hi', including the
+// tuples that begin with 'hi'.
+func Between(lo Tuple, hi Tuple) (min string, max string) {
+	_, max = hi.Range()
+	return lo.Pack(), max
+}
+
+// strings are terminated with 0x00;
+// 0x00 bytes are escaped as 0x00 0xff
+func appendString(b []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		b = append(b, s[i])
+		if s[i] == 0x00 {
+			b = append(b, 0xff)
+		}
+	}
+	return append(b, 0x00)
+}
+
+// flipping the sign bit makes
+// big-endian signed integers sort
+func appendInt(b []byte, i int64) []byte {
+	return appendUint(append(b, tagInt), uint64(i)^(1<<63))
+}
+
+func appendUint(b []byte, u uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], u)
+	return append(b, buf[:]...)
+}
+
+// Unpack decodes a packed tuple.
+func Unpack(s string) (Tuple, error) {
+	var t Tuple
+	for len(s) > 0 {
+		tag := s[0]
+		s = s[1:]
+		switch tag {
+		case tagString, tagBytes:
+			var str []byte
+			var ok bool
+			str, s, ok = readString(s)
+			if !ok {
+				return nil, ErrCorrupt
+			}
+			if tag == tagString {
+				t = append(t, string(str))
+			} else {
+				t = append(t, str)
+			}
+		case tagInt, tagUint:
+			if len(s) < 8 {
+				return nil, ErrCorrupt
+			}
+			u := binary.BigEndian.Uint64([]byte(s[:8]))
+			s = s[8:]
+			if tag == tagInt {
+				t = append(t, int64(u^(1<<63)))
+			} else {
+				t = append(t, u)
+			}
+		case tagTime:
+			if len(s) < 12 {
+				return nil, ErrCorrupt
+			}
+			sec := int64(binary.BigEndian.Uint64([]byte(s[:8])) ^ (1 << 63))
+			ns := binary.BigEndian.Uint32([]byte(s[8:12]))
+			s = s[12:]
+			t = append(t, time.Unix(sec, int64(ns)).UTC())
+		case tagFalse:
+			t = append(t, false)
+		case tagTrue:
+			t = append(t, true)
+		default:
+			return nil, ErrCorrupt
+		}
+	}
+	return t, nil
+}
+
+// read an escaped string
+func readString(s string) ([]byte, string, bool) {
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != 0x00 {
+			out = append(out, s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == 0xff {
+			out = append(out, 0x00)
+			i++
+			continue
+		}
+		return out, s[i+1:], true
+	}
+	return nil, "", false
+}
@@ -0,0 +1,103 @@
+package tuple
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	now := time.Unix(1414000000, 123456789).UTC()
+	in := Tuple{"tenant\x00x", []byte{0, 1, 0xff}, int64(-42), uint64(1 << 63), now, true, false, ""}
+	out, err := Unpack(in.Pack())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("in: %#v\nout: %#v", in, out)
+	}
+
+	// other integer types are widened
+	out, err = Unpack(Pack(int8(-1), uint16(7), 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, Tuple{int64(-1), uint64(7), int64(3)}) {
+		t.Errorf("out: %#v", out)
+	}
+
+	for _, bad := range []string{"\x02abc", "\x10\x00", "\x12\x00\x00", "\x99"} {
+		if _, err := Unpack(bad); err != ErrCorrupt {
+			t.Errorf("Unpack(%q): expected ErrCorrupt; got %v", bad, err)
+		}
+	}
+}
+
+func TestOrder(t *testing.T) {
+	epoch := time.Unix(0, 0)
+	// in ascending order
+	tuples := []Tuple{
+		{"a"},
+		{"a", int64(-1 << 40)},
+		{"a", int64(-1)},
+		{"a", int64(0)},
+		{"a", int64(1)},
+		{"a", int64(1 << 40)},
+		{"a\x00"},
+		{"ab"},
+		{"b", epoch.Add(-time.Hour)},
+		{"b", epoch.Add(-time.Nanosecond)},
+		{"b", epoch},
+		{"b", epoch.Add(time.Nanosecond)},
+		{"b", epoch.Add(100 * 365 * 24 * time.Hour)},
+		{"b", false},
+		{"b", true},
+		{"c", uint64(1)},
+		{"c", uint64(1 << 63)},
+	}
+	packed := make([]string, len(tuples))
+	for i, tp := range tuples {
+		packed[i] = tp.Pack()
+	}
+	if !sort.StringsAreSorted(packed) {
+		for i := 1; i < len(packed); i++ {
+			if packed[i-1] >= packed[i] {
+				t.Errorf("%v >= %v", tuples[i-1], tuples[i])
+			}
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	min, max := Tuple{"user", int64(7)}.Range()
+	in := []string{
+		Pack("user", 7),
+		Pack("user", 7, "x"),
+		Pack("user", 7, time.Now(), true),
+	}
+	out := []string{
+		Pack("user", 6, "x"),
+		Pack("user", 8),
+		Pack("users"),
+		Pack("user"),
+	}
+	for _, s := range in {
+		if s < min || s > max {
+			t.Errorf("%q should be in range", s)
+		}
+	}
+	for _, s := range out {
+		if s >= min && s <= max {
+			t.Errorf("%q shouldn't be in range", s)
+		}
+	}
+
+	lo, hi := Between(Tuple{"t", 10}, Tuple{"t", 20})
+	if s := Pack("t", 20, "x"); s < lo || s > hi {
+		t.Error("Between should include extensions of 'hi'")
+	}
+	if s := Pack("t", 21); s >= lo && s <= hi {
+		t.Error("Between should exclude tuples after 'hi'")
+	}
+}
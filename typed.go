@@ -0,0 +1,148 @@
+//go:build go1.18
+// +build go1.18
+
+package rkive
+
+import "context"
+
+// TypedBucket is a bucket whose values all have
+// the type T. PT is the pointer type *T, which must
+// implement Object. Type parameters are usually
+// inferred from the call to Typed:
+//
+//   people := rkive.Typed[Person](riak.Bucket("people"))
+//   bob, err := people.Get("bob") // bob is a *Person
+//
+// TypedBucket does not require T to implement
+// Duplicator; new values are allocated with new(T).
+type TypedBucket[T any, PT interface {
+	*T
+	Object
+}] struct {
+	b *Bucket
+}
+
+// Typed returns a TypedBucket for the values of 'b'.
+func Typed[T any, PT interface {
+	*T
+	Object
+}](b *Bucket) *TypedBucket[T, PT] {
+	return &TypedBucket[T, PT]{b: b}
+}
+
+// Bucket returns the underlying bucket.
+func (t *TypedBucket[T, PT]) Bucket() *Bucket { return t.b }
+
+// Get fetches the value at 'key'.
+func (t *TypedBucket[T, PT]) Get(key string) (*T, error) {
+	v := new(T)
+	err := t.b.Fetch(PT(v), key)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Create writes a new value. (See: Bucket.New)
+func (t *TypedBucket[T, PT]) Create(v *T, key *string) error { return t.b.New(PT(v), key) }
+
+// Put stores a value. (See: Bucket.Store)
+func (t *TypedBucket[T, PT]) Put(v *T) error { return t.b.Store(PT(v)) }
+
+// Push conditionally stores a value. (See: Bucket.Push)
+func (t *TypedBucket[T, PT]) Push(v *T) error { return t.b.Push(PT(v)) }
+
+// Modify fetches the value at 'key', applies 'fn' to it,
+// and pushes the result, re-fetching and re-applying 'fn'
+// if the value was modified concurrently. 'fn' should return
+// ErrDone if the change it wanted has already been made.
+// (See: PushChangeset)
+func (t *TypedBucket[T, PT]) Modify(key string, fn func(*T) error) (*T, error) {
+	v, err := t.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	err = t.b.c.PushChangeset(PT(v), func(o Object) error {
+		return fn((*T)(o.(PT)))
+	}, nil)
+	if err == ErrDone {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Query returns an iterator over the values matching
+// a secondary index lookup. Keys are streamed from the
+// query and fetched as they arrive, in a window of
+// opts.Window fetches. (See: IndexStream.Fetch)
+func (t *TypedBucket[T, PT]) Query(ctx context.Context, opts *FetchOpts, index string, value string) (*TypedIter[T, PT], error) {
+	return t.stream(ctx, opts, t.b.Query().Match(index, value))
+}
+
+// QueryRange returns an iterator over the values
+// matching a secondary index range query. (See: Query)
+func (t *TypedBucket[T, PT]) QueryRange(ctx context.Context, opts *FetchOpts, index string, min int64, max int64) (*TypedIter[T, PT], error) {
+	return t.stream(ctx, opts, t.b.Query().RangeInt(index, min, max))
+}
+
+func (t *TypedBucket[T, PT]) stream(ctx context.Context, opts *FetchOpts, q *IndexQuery) (*TypedIter[T, PT], error) {
+	s, err := q.Stream()
+	if err != nil {
+		return nil, err
+	}
+	return &TypedIter[T, PT]{it: s.Fetch(ctx, typedNew[T, PT]{}, opts)}, nil
+}
+
+// MultiGet fetches values asynchronously, returning
+// the results through an iterator. (See: MultiFetchContext)
+func (t *TypedBucket[T, PT]) MultiGet(ctx context.Context, opts *FetchOpts, keys ...string) *TypedIter[T, PT] {
+	return &TypedIter[T, PT]{it: t.b.MultiFetchContext(ctx, typedNew[T, PT]{}, opts, keys...)}
+}
+
+// typedNew is the Duplicator passed to
+// FetchIter, which only calls NewEmpty
+type typedNew[T any, PT interface {
+	*T
+	Object
+}] struct {
+	Object
+}
+
+func (typedNew[T, PT]) NewEmpty() Object { return PT(new(T)) }
+
+// TypedIter is a FetchIter over typed values.
+// Like a FetchIter, it must be closed (or its
+// context cancelled) if it is abandoned before
+// Next returns an error.
+type TypedIter[T any, PT interface {
+	*T
+	Object
+}] struct {
+	it *FetchIter
+}
+
+// TypedResult is the result of an asynchronous
+// fetch of a typed value. If Error is non-nil,
+// Value is usually the zero value.
+type TypedResult[T any] struct {
+	Key   string
+	Value *T
+	Error error
+}
+
+// Next returns the next result. Errors from
+// individual fetches are returned in the Error
+// field of the result. (See: FetchIter.Next)
+func (it *TypedIter[T, PT]) Next() (*TypedResult[T], error) {
+	r, err := it.it.Next()
+	if err != nil {
+		return nil, err
+	}
+	return &TypedResult[T]{Key: r.Key, Value: (*T)(r.Value.(PT)), Error: r.Error}, nil
+}
+
+// Close stops the iterator. (See: FetchIter.Close)
+func (it *TypedIter[T, PT]) Close() { it.it.Close() }
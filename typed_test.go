@@ -0,0 +1,84 @@
+//go:build riak && go1.18
+// +build riak,go1.18
+
+package rkive
+
+import (
+	"bytes"
+	"context"
+	check "gopkg.in/check.v1"
+	"io"
+)
+
+func (s *riakAsync) TestTypedBucket(c *check.C) {
+	tests := Typed[TestObject](s.cl.Bucket("testbucket"))
+
+	ob := &TestObject{Data: []byte("typed")}
+	ob.Info().AddIndex("typedIdx", "typed")
+	err := tests.Create(ob, nil)
+	if err != nil {
+		c.Fatal(err)
+	}
+
+	got, err := tests.Get(ob.Info().Key())
+	if err != nil {
+		c.Fatal(err)
+	}
+	if !bytes.Equal(got.Data, ob.Data) {
+		c.Errorf("Expected %q; got %q", ob.Data, got.Data)
+	}
+
+	got, err = tests.Modify(ob.Info().Key(), func(t *TestObject) error {
+		t.Data = []byte("modified")
+		return nil
+	})
+	if err != nil {
+		c.Fatal(err)
+	}
+	if string(got.Data) != "modified" {
+		c.Errorf("Expected %q; got %q", "modified", got.Data)
+	}
+
+	it, err := tests.Query(context.Background(), &FetchOpts{Window: 2}, "typedIdx", "typed")
+	if err != nil {
+		c.Fatal(err)
+	}
+	n := 0
+	for {
+		res, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.Fatal(err)
+		}
+		if res.Error != nil {
+			c.Fatal(res.Error)
+		}
+		if res.Value.Info().GetIndex("typedIdx") != "typed" {
+			c.Errorf("bad index value %q", res.Value.Info().GetIndex("typedIdx"))
+		}
+		n++
+	}
+	if n < 1 {
+		c.Error("no values matched the query")
+	}
+
+	it = tests.MultiGet(context.Background(), &FetchOpts{Window: 2}, ob.Info().Key())
+	defer it.Close()
+	for {
+		res, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.Fatal(err)
+		}
+		if res.Error != nil {
+			c.Fatal(res.Error)
+		}
+		if string(res.Value.Data) != "modified" {
+			c.Errorf("Expected %q; got %q", "modified", res.Value.Data)
+		}
+	}
+}
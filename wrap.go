@@ -0,0 +1,299 @@
+package rkive
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// A ValueCodec marshals and unmarshals
+// the values of objects created with Wrap.
+type ValueCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSON is a ValueCodec that uses encoding/json.
+var JSON ValueCodec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (j jsonCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (j jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// Wrapped is an Object (and a Duplicator) that
+// wraps a pointer to an arbitrary struct. (See: Wrap)
+type Wrapped struct {
+	info   Info
+	v      reflect.Value
+	codec  ValueCodec
+	fields *tagFields
+}
+
+// Wrap turns a pointer to a struct into an Object,
+// using 'codec' to marshal and unmarshal the struct.
+// Struct fields tagged with `riak:"..."` are kept in
+// sync with the object's metadata:
+//
+//   `riak:"key"`            the object's key (string)
+//   `riak:"index=name"`     the "name_bin" secondary index (string or []string)
+//   `riak:"index_int=name"` the "name_int" secondary index (any integer, or a slice of them other than []byte)
+//   `riak:"meta=name"`      the "name" usermeta value (string)
+//
+// Tagged fields are written to the metadata on every write,
+// and populated from the metadata on every read. Empty string
+// fields remove the corresponding index or metadata entry, and
+// so do empty slices. Single integer fields are always written,
+// since zero is a valid index value: a zero field creates a
+// "name_int" entry of 0. Use a slice for an optional integer index.
+// Wrap panics if 'v' is not a pointer to a struct, or if a
+// tag is malformed or names a reserved "rkive-" meta key.
+func Wrap(v interface{}, codec ValueCodec) *Wrapped {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("rkive: Wrap of non-pointer-to-struct type %T", v))
+	}
+	w := &Wrapped{
+		v:      rv,
+		codec:  codec,
+		fields: getFields(rv.Type().Elem()),
+	}
+	if k := w.riakKey(); k != "" {
+		w.info.key = []byte(k)
+	}
+	return w
+}
+
+// Value returns the wrapped pointer.
+func (w *Wrapped) Value() interface{} { return w.v.Interface() }
+
+// Info implements part of the Object interface.
+func (w *Wrapped) Info() *Info { return &w.info }
+
+// Marshal implements part of the Object interface.
+// It also writes tagged fields into the object's metadata.
+func (w *Wrapped) Marshal() ([]byte, error) {
+	sv := w.v.Elem()
+	for _, f := range w.fields.idx {
+		fv := sv.Field(f.num)
+		if f.multi {
+			w.info.RemoveIndex(f.name)
+			for i := 0; i < fv.Len(); i++ {
+				w.info.AddIndexValue(f.name, fv.Index(i).String())
+			}
+		} else if s := fv.String(); s != "" {
+			w.info.SetIndex(f.name, s)
+		} else {
+			w.info.RemoveIndex(f.name)
+		}
+	}
+	for _, f := range w.fields.idxint {
+		fv := sv.Field(f.num)
+		if f.multi {
+			w.info.RemoveIndexInt(f.name)
+			for i := 0; i < fv.Len(); i++ {
+				w.info.AddIndexIntValue(f.name, intField(fv.Index(i)))
+			}
+		} else {
+			w.info.SetIndexInt(f.name, intField(fv))
+		}
+	}
+	for _, f := range w.fields.meta {
+		if s := sv.Field(f.num).String(); s != "" {
+			w.info.SetMeta(f.name, s)
+		} else {
+			w.info.RemoveMeta(f.name)
+		}
+	}
+	return w.codec.Marshal(w.v.Interface())
+}
+
+// Unmarshal implements part of the Object interface.
+// It also populates tagged fields from the object's metadata.
+func (w *Wrapped) Unmarshal(b []byte) error {
+	sv := w.v.Elem()
+	sv.Set(reflect.Zero(sv.Type()))
+	err := w.codec.Unmarshal(b, w.v.Interface())
+	if err != nil {
+		return err
+	}
+	for _, f := range w.fields.idx {
+		fv := sv.Field(f.num)
+		if !f.multi {
+			fv.SetString(w.info.GetIndex(f.name))
+			continue
+		}
+		vals := w.info.IndexValues(f.name)
+		if vals == nil {
+			continue
+		}
+		fv.Set(reflect.MakeSlice(fv.Type(), len(vals), len(vals)))
+		for i, v := range vals {
+			fv.Index(i).SetString(v)
+		}
+	}
+	for _, f := range w.fields.idxint {
+		fv := sv.Field(f.num)
+		if !f.multi {
+			if i := w.info.GetIndexInt(f.name); i != nil {
+				setIntField(fv, *i)
+			}
+			continue
+		}
+		vals := w.info.IndexIntValues(f.name)
+		if vals == nil {
+			continue
+		}
+		fv.Set(reflect.MakeSlice(fv.Type(), len(vals), len(vals)))
+		for i, v := range vals {
+			setIntField(fv.Index(i), v)
+		}
+	}
+	for _, f := range w.fields.meta {
+		sv.Field(f.num).SetString(w.info.GetMeta(f.name))
+	}
+	w.setRiakKey(w.info.Key())
+	return nil
+}
+
+// NewEmpty implements the Duplicator interface.
+func (w *Wrapped) NewEmpty() Object {
+	return Wrap(reflect.New(w.v.Type().Elem()).Interface(), w.codec)
+}
+
+func (w *Wrapped) riakKey() string {
+	if w.fields.key < 0 {
+		return ""
+	}
+	return w.v.Elem().Field(w.fields.key).String()
+}
+
+func (w *Wrapped) setRiakKey(k string) {
+	if w.fields.key >= 0 && k != "" {
+		w.v.Elem().Field(w.fields.key).SetString(k)
+	}
+}
+
+// keyed objects mirror their
+// key in a field (see Wrap)
+type keyed interface {
+	riakKey() string
+	setRiakKey(string)
+}
+
+// tagged struct field
+type tagField struct {
+	num   int    // field number
+	name  string // index or meta name
+	multi bool   // slice of index values
+}
+
+// tagged fields of a struct type
+type tagFields struct {
+	key    int // -1 if none
+	idx    []tagField
+	idxint []tagField
+	meta   []tagField
+}
+
+var (
+	fieldLock  sync.RWMutex
+	fieldCache = map[reflect.Type]*tagFields{}
+)
+
+func getFields(t reflect.Type) *tagFields {
+	fieldLock.RLock()
+	tf, ok := fieldCache[t]
+	fieldLock.RUnlock()
+	if ok {
+		return tf
+	}
+	tf = parseFields(t)
+	fieldLock.Lock()
+	fieldCache[t] = tf
+	fieldLock.Unlock()
+	return tf
+}
+
+func parseFields(t reflect.Type) *tagFields {
+	tf := &tagFields{key: -1}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("riak")
+		if tag == "" {
+			continue
+		}
+		if sf.PkgPath != "" {
+			panic(fmt.Sprintf("rkive: riak tag on unexported field %s.%s", t, sf.Name))
+		}
+		kind := sf.Type.Kind()
+		if tag == "key" {
+			if kind != reflect.String {
+				panic(fmt.Sprintf("rkive: key field %s.%s must be a string", t, sf.Name))
+			}
+			tf.key = i
+			continue
+		}
+		eq := strings.IndexByte(tag, '=')
+		if eq <= 0 || eq == len(tag)-1 {
+			panic(fmt.Sprintf("rkive: malformed riak tag %q on %s.%s", tag, t, sf.Name))
+		}
+		f := tagField{num: i, name: tag[eq+1:]}
+		switch tag[:eq] {
+		case "index":
+			f.multi = kind == reflect.Slice && sf.Type.Elem().Kind() == reflect.String
+			if kind != reflect.String && !f.multi {
+				panic(fmt.Sprintf("rkive: index field %s.%s must be a string or a slice of strings", t, sf.Name))
+			}
+			tf.idx = append(tf.idx, f)
+		case "index_int":
+			// ([]byte is data, not a list of integers)
+			f.multi = kind == reflect.Slice && isInt(sf.Type.Elem().Kind()) && sf.Type.Elem().Kind() != reflect.Uint8
+			if !isInt(kind) && !f.multi {
+				panic(fmt.Sprintf("rkive: index_int field %s.%s must be an integer or a slice of integers other than []byte", t, sf.Name))
+			}
+			tf.idxint = append(tf.idxint, f)
+		case "meta":
+			if kind != reflect.String {
+				panic(fmt.Sprintf("rkive: meta field %s.%s must be a string", t, sf.Name))
+			}
+			if strings.HasPrefix(f.name, string(metaPrefix)) {
+				panic(fmt.Sprintf("rkive: meta field %s.%s uses reserved name %q", t, sf.Name, f.name))
+			}
+			tf.meta = append(tf.meta, f)
+		default:
+			panic(fmt.Sprintf("rkive: unknown riak tag %q on %s.%s", tag, t, sf.Name))
+		}
+	}
+	return tf
+}
+
+func isInt(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func intField(v reflect.Value) int64 {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	default:
+		return v.Int()
+	}
+}
+
+func setIntField(v reflect.Value, i int64) {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(i))
+	default:
+		v.SetInt(i)
+	}
+}
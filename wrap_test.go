@@ -0,0 +1,133 @@
+package rkive
+
+import (
+	"github.com/philhofer/rkive/rpbc"
+	"testing"
+)
+
+type wrapUser struct {
+	ID      string `riak:"key" json:"-"`
+	Email   string `riak:"index=email" json:"email"`
+	Created uint32 `riak:"index_int=created" json:"created"`
+	Owner   string `riak:"meta=owner" json:"-"`
+	Name    string `json:"name"`
+}
+
+func TestWrap(t *testing.T) {
+	u := &wrapUser{
+		ID:      "joe",
+		Email:   "joe@example.com",
+		Created: 1414000000,
+		Owner:   "admin",
+		Name:    "Joe",
+	}
+	w := Wrap(u, JSON)
+	if w.Info().Key() != "joe" {
+		t.Errorf("key: %q", w.Info().Key())
+	}
+
+	ctnt := &rpbc.RpbContent{}
+	err := writeContent(w, ctnt, nil, nil, &bucketConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(get(&ctnt.Indexes, ustr("email_bin"))) != "joe@example.com" {
+		t.Errorf("indexes: %v", w.Info().Indexes())
+	}
+	if string(get(&ctnt.Indexes, ustr("created_int"))) != "1414000000" {
+		t.Errorf("indexes: %v", w.Info().Indexes())
+	}
+	if string(get(&ctnt.Usermeta, ustr("owner"))) != "admin" {
+		t.Errorf("meta: %v", w.Info().Metas())
+	}
+
+	nw := w.NewEmpty().(*Wrapped)
+	nw.Info().key = []byte("joe")
+	err = readContent(nw, ctnt, &bucketConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nu := nw.Value().(*wrapUser); *nu != *u {
+		t.Errorf("expected %+v; got %+v", u, nu)
+	}
+
+	// empty fields remove entries
+	u.Owner = ""
+	err = writeContent(w, ctnt, nil, nil, &bucketConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if get(&ctnt.Usermeta, ustr("owner")) != nil {
+		t.Error("owner meta should have been removed")
+	}
+}
+
+type wrapTagged struct {
+	Tags   []string `riak:"index=tag" json:"-"`
+	Scores []int32  `riak:"index_int=score" json:"-"`
+}
+
+func TestWrapMultiIndex(t *testing.T) {
+	w := Wrap(&wrapTagged{Tags: []string{"a", "b"}, Scores: []int32{3, -1}}, JSON)
+	ctnt := &rpbc.RpbContent{}
+	err := writeContent(w, ctnt, nil, nil, &bucketConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ctnt.Indexes) != 4 {
+		t.Errorf("indexes: %v", w.Info().Indexes())
+	}
+
+	out := &wrapTagged{}
+	err = readContent(Wrap(out, JSON), ctnt, &bucketConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Tags) != 2 || out.Tags[0] != "a" || out.Tags[1] != "b" {
+		t.Errorf("tags: %v", out.Tags)
+	}
+	if len(out.Scores) != 2 || out.Scores[0] != 3 || out.Scores[1] != -1 {
+		t.Errorf("scores: %v", out.Scores)
+	}
+
+	// removing a value from the field
+	// removes it from the index
+	w = Wrap(out, JSON)
+	out.Tags = out.Tags[:1]
+	ctnt = &rpbc.RpbContent{}
+	writeContent(w, ctnt, nil, nil, &bucketConf{})
+	if vals := w.Info().IndexValues("tag"); len(vals) != 1 || vals[0] != "a" {
+		t.Errorf("tags after removal: %v", vals)
+	}
+}
+
+func TestWrapBadTags(t *testing.T) {
+	bad := []interface{}{
+		wrapUser{},
+		&struct {
+			N int `riak:"index=n"`
+		}{},
+		&struct {
+			S string `riak:"index"`
+		}{},
+		&struct {
+			S string `riak:"bogus=s"`
+		}{},
+		&struct {
+			S string `riak:"meta=rkive-s"`
+		}{},
+		&struct {
+			B []byte `riak:"index_int=b"`
+		}{},
+	}
+	for _, v := range bad {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Wrap(%T) should panic", v)
+				}
+			}()
+			Wrap(v, JSON)
+		}()
+	}
+}